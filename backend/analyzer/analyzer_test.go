@@ -45,7 +45,7 @@ func TestDropStaleRecommendations_HasRecent(t *testing.T) {
 
 	// Mock database call
 	models.DB = models.NewTestDB(stockRatings)
-	models.DB.Create(&stock)
+	models.DB.Save(&stock)
 
 	// Run analysis
 	analyzer := DropStaleRecommendations{}
@@ -148,3 +148,23 @@ func TestPriceChangePonderedRecommendation_NoRatings(t *testing.T) {
 	models.DB.First(&updatedStock, "ticker = ?", stock.Ticker)
 	assert.Equal(t, "N/A", updatedStock.Recommendation)
 }
+
+func TestPriceChangePonderedRecommendation_MixedCurrencies(t *testing.T) {
+	// ratings are counted whatever the currency of their targets
+	stock := models.Stock{Ticker: "SAP", Recommendation: "N/A", LastPrice: 100, Currency: "EUR"}
+	stockRatings := []models.StockRating{
+		{Ticker: "SAP", Brokerage: "A", RatingTo: "Buy", TargetTo: 120, Currency: "USD"},
+		{Ticker: "SAP", Brokerage: "B", RatingTo: "Outperform", TargetTo: 100, Currency: "GBP"},
+		{Ticker: "SAP", Brokerage: "C", RatingTo: "Neutral", TargetTo: 105, Currency: "EUR"},
+	}
+
+	models.DB = models.NewTestDB(stockRatings)
+	models.DB.Save(&stock)
+
+	analyzer := PriceChangePonderedRecommendation{}
+	analyzer.Analyze(&stock)
+
+	var updatedStock models.Stock
+	models.DB.First(&updatedStock, "ticker = ?", stock.Ticker)
+	assert.Equal(t, "Buy", updatedStock.Recommendation)
+}
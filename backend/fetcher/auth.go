@@ -0,0 +1,194 @@
+package fetcher
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// IAuthenticator adds the credentials of an upstream API to its requests
+type IAuthenticator interface {
+	// Authenticate sets the credentials on the request. It is called before every attempt of a request
+	Authenticate(req *http.Request) error
+	// RateLimited is told when the upstream rate limited a request, so the credentials it used can be rotated
+	RateLimited(req *http.Request)
+}
+
+// IQueryAuthenticator is implemented by the authenticators that set their credentials in the query string
+type IQueryAuthenticator interface {
+	// QueryParams are the query parameters carrying the credentials
+	QueryParams() []string
+}
+
+// AuthConfig describes how to authenticate against an upstream API
+type AuthConfig struct {
+	// Type is one of none, bearer, header, query and basic
+	Type string
+	// Tokens are the tokens or API keys used by the bearer, header and query types. They are rotated whenever
+	// the upstream rate limits the one in use
+	Tokens []string
+	// Header is the header carrying the API key of the header type. Defaults to X-API-Key
+	Header string
+	// Param is the query parameter carrying the API key of the query type. Defaults to api_key
+	Param    string
+	Username string
+	Password string
+}
+
+// NewAuthenticator builds the authenticator described by the configuration
+func NewAuthenticator(config AuthConfig) (IAuthenticator, error) {
+	switch config.Type {
+	case "", "none":
+		return NoAuth{}, nil
+	case "bearer":
+		return &BearerAuth{Tokens: NewTokenRotation(config.Tokens)}, nil
+	case "header":
+		header := config.Header
+		if header == "" {
+			header = "X-API-Key"
+		}
+		return &HeaderApiKeyAuth{Header: header, Tokens: NewTokenRotation(config.Tokens)}, nil
+	case "query":
+		param := config.Param
+		if param == "" {
+			param = "api_key"
+		}
+		return &QueryApiKeyAuth{Param: param, Tokens: NewTokenRotation(config.Tokens)}, nil
+	case "basic":
+		if config.Username == "" {
+			return nil, errors.New("basic auth needs a username")
+		}
+		return &BasicAuth{Username: config.Username, Password: config.Password}, nil
+	default:
+		return nil, fmt.Errorf("unknown auth type %q, expected one of none, bearer, header, query and basic", config.Type)
+	}
+}
+
+// TokenRotation holds several tokens of an upstream and hands out one at a time, moving on to the next one when
+// the current one gets rate limited
+type TokenRotation struct {
+	mu      sync.Mutex
+	tokens  []string
+	current int
+}
+
+// NewTokenRotation creates a rotation over the given tokens, ignoring the empty ones
+func NewTokenRotation(tokens []string) *TokenRotation {
+	rotation := &TokenRotation{}
+	for _, token := range tokens {
+		if token = strings.TrimSpace(token); token != "" {
+			rotation.tokens = append(rotation.tokens, token)
+		}
+	}
+	return rotation
+}
+
+// Token gets the token in use, if any
+func (t *TokenRotation) Token() (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.tokens) == 0 {
+		return "", false
+	}
+	return t.tokens[t.current], true
+}
+
+// Rotate moves on to the next token if the rate limited one is still the one in use. Concurrent requests that were
+// rate limited with the same token only rotate it once
+func (t *TokenRotation) Rotate(limited string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.tokens) < 2 || t.tokens[t.current] != limited {
+		return
+	}
+	previous := t.current
+	t.current = (t.current + 1) % len(t.tokens)
+	log.Printf("Token %d of %d was rate limited, rotating to token %d", previous+1, len(t.tokens), t.current+1)
+}
+
+// NoAuth sends requests without credentials
+type NoAuth struct{}
+
+func (NoAuth) Authenticate(*http.Request) error { return nil }
+
+func (NoAuth) RateLimited(*http.Request) {}
+
+// BearerAuth sends a token in the Authorization header
+type BearerAuth struct {
+	Tokens *TokenRotation
+}
+
+func (b *BearerAuth) Authenticate(req *http.Request) error {
+	token, ok := b.Tokens.Token()
+	if !ok {
+		return errors.New("no bearer token provided")
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	return nil
+}
+
+func (b *BearerAuth) RateLimited(req *http.Request) {
+	b.Tokens.Rotate(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+}
+
+// HeaderApiKeyAuth sends an API key in a custom header
+type HeaderApiKeyAuth struct {
+	Header string
+	Tokens *TokenRotation
+}
+
+func (h *HeaderApiKeyAuth) Authenticate(req *http.Request) error {
+	token, ok := h.Tokens.Token()
+	if !ok {
+		return errors.New("no API key provided")
+	}
+	req.Header.Set(h.Header, token)
+	return nil
+}
+
+func (h *HeaderApiKeyAuth) RateLimited(req *http.Request) {
+	h.Tokens.Rotate(req.Header.Get(h.Header))
+}
+
+// QueryApiKeyAuth sends an API key in the query string
+type QueryApiKeyAuth struct {
+	Param  string
+	Tokens *TokenRotation
+}
+
+func (q *QueryApiKeyAuth) Authenticate(req *http.Request) error {
+	token, ok := q.Tokens.Token()
+	if !ok {
+		return errors.New("no API key provided")
+	}
+	query := req.URL.Query()
+	query.Set(q.Param, token)
+	req.URL.RawQuery = query.Encode()
+	return nil
+}
+
+func (q *QueryApiKeyAuth) RateLimited(req *http.Request) {
+	q.Tokens.Rotate(req.URL.Query().Get(q.Param))
+}
+
+func (q *QueryApiKeyAuth) QueryParams() []string {
+	return []string{q.Param}
+}
+
+// BasicAuth sends a username and password through HTTP basic auth
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+func (b *BasicAuth) Authenticate(req *http.Request) error {
+	req.SetBasicAuth(b.Username, b.Password)
+	return nil
+}
+
+func (b *BasicAuth) RateLimited(*http.Request) {}
@@ -0,0 +1,137 @@
+package fetcher
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// --- TEST CASE 1: Each auth type sets its credentials ---
+func TestNewAuthenticator(t *testing.T) {
+	cases := []struct {
+		config AuthConfig
+		check  func(t *testing.T, req *http.Request)
+	}{
+		{AuthConfig{Type: "none"}, func(t *testing.T, req *http.Request) {
+			assert.Empty(t, req.Header.Get("Authorization"))
+		}},
+		{AuthConfig{Type: "bearer", Tokens: []string{"abc"}}, func(t *testing.T, req *http.Request) {
+			assert.Equal(t, "Bearer abc", req.Header.Get("Authorization"))
+		}},
+		{AuthConfig{Type: "header", Tokens: []string{"abc"}}, func(t *testing.T, req *http.Request) {
+			assert.Equal(t, "abc", req.Header.Get("X-API-Key"))
+		}},
+		{AuthConfig{Type: "header", Header: "X-Token", Tokens: []string{"abc"}}, func(t *testing.T, req *http.Request) {
+			assert.Equal(t, "abc", req.Header.Get("X-Token"))
+		}},
+		{AuthConfig{Type: "query", Param: "apikey", Tokens: []string{"abc"}}, func(t *testing.T, req *http.Request) {
+			assert.Equal(t, "abc", req.URL.Query().Get("apikey"))
+			assert.Equal(t, "AAPL", req.URL.Query().Get("tickers"))
+		}},
+		{AuthConfig{Type: "basic", Username: "user", Password: "secret"}, func(t *testing.T, req *http.Request) {
+			username, password, ok := req.BasicAuth()
+			assert.True(t, ok)
+			assert.Equal(t, "user", username)
+			assert.Equal(t, "secret", password)
+		}},
+	}
+
+	for _, c := range cases {
+		auth, err := NewAuthenticator(c.config)
+		assert.NoError(t, err)
+
+		req, _ := http.NewRequest("GET", "http://localhost/quotes?tickers=AAPL", nil)
+		assert.NoError(t, auth.Authenticate(req))
+		c.check(t, req)
+	}
+
+	_, err := NewAuthenticator(AuthConfig{Type: "kerberos"})
+	assert.Error(t, err)
+
+	auth, err := NewAuthenticator(AuthConfig{Type: "bearer"})
+	assert.NoError(t, err)
+	req, _ := http.NewRequest("GET", "http://localhost", nil)
+	assert.Error(t, auth.Authenticate(req))
+}
+
+// --- TEST CASE 2: Tokens are rotated when the upstream rate limits them ---
+func TestTokenRotation_RateLimited(t *testing.T) {
+	var limited int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") == "first" {
+			atomic.AddInt32(&limited, 1)
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`[{"ticker":"AAPL"}]`))
+	}))
+	defer server.Close()
+
+	auth, err := NewAuthenticator(AuthConfig{Type: "header", Tokens: []string{"first", "second"}})
+	assert.NoError(t, err)
+
+	fetcher := BasicStockInfoFetcher{Auth: auth, Retry: testRetryPolicy}
+	stock, err := fetcher.FetchStockInfo(context.Background(), "AAPL", server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "AAPL", stock.Ticker)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&limited))
+
+	// the rotation sticks for later requests
+	_, err = fetcher.FetchStockInfo(context.Background(), "AAPL", server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&limited))
+}
+
+// --- TEST CASE 3: Rate limits seen with a token already rotated away don't rotate again ---
+func TestTokenRotation_RotatesOnce(t *testing.T) {
+	rotation := NewTokenRotation([]string{"a", "b", "c", ""})
+
+	rotation.Rotate("a")
+	rotation.Rotate("a")
+	token, ok := rotation.Token()
+	assert.True(t, ok)
+	assert.Equal(t, "b", token)
+
+	rotation.Rotate("b")
+	rotation.Rotate("c")
+	token, _ = rotation.Token()
+	assert.Equal(t, "a", token)
+}
+
+// --- TEST CASE 4: The info fetcher sends its bearer token ---
+func TestFetchStockInfo_SendsBearerToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+mockToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`[{"ticker":"AAPL"}]`))
+	}))
+	defer server.Close()
+
+	fetcher := BasicStockInfoFetcher{BearerToken: mockToken}
+	_, err := fetcher.FetchStockInfo(context.Background(), "AAPL", server.URL)
+	assert.NoError(t, err)
+}
+
+// --- TEST CASE 5: The API key sent in the query is left out of the errors of failed requests ---
+func TestQueryApiKeyAuth_RedactsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL
+	server.Close()
+
+	auth := &QueryApiKeyAuth{Param: "api_key", Tokens: NewTokenRotation([]string{"secret-token"})}
+	fetcher := BasicStockInfoFetcher{Auth: auth}
+	_, err := fetcher.FetchStockInfo(context.Background(), "AAPL", url)
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret-token")
+	assert.Contains(t, err.Error(), url)
+
+	req, _ := http.NewRequest("GET", url+"/ratings?next_page=A", nil)
+	_, err = RetryPolicy{}.Do(http.DefaultClient, req, auth)
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret-token")
+}
@@ -0,0 +1,223 @@
+package fetcher
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a circuit breaker
+type CircuitState int
+
+const (
+	// CircuitClosed lets every call through
+	CircuitClosed CircuitState = iota
+	// CircuitOpen skips every call, as the upstream is considered down
+	CircuitOpen
+	// CircuitHalfOpen lets a single trial call through to check whether the upstream is back
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// ErrCircuitOpen is returned instead of calling an upstream whose circuit is open
+var ErrCircuitOpen = errors.New("circuit open")
+
+const (
+	// DefaultFailureThreshold is the amount of consecutive failures that opens a circuit when none is configured
+	DefaultFailureThreshold = 5
+	// DefaultOpenTimeout is how long a circuit stays open when no timeout is configured
+	DefaultOpenTimeout = 60 * time.Second
+)
+
+// CircuitBreaker stops calling an upstream after FailureThreshold consecutive failures. Once OpenTimeout has passed,
+// a single trial call is let through: the circuit closes if it succeeds and opens again otherwise. Failures are
+// transport errors and 5xx and 408 responses
+type CircuitBreaker struct {
+	// Name identifies the upstream in the logs and in the status
+	Name             string
+	FailureThreshold int
+	OpenTimeout      time.Duration
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	trial    bool
+	lastErr  error
+}
+
+// CircuitStatus is a snapshot of the state of a circuit breaker
+type CircuitStatus struct {
+	Name                string
+	State               CircuitState
+	ConsecutiveFailures int
+	// OpenedAt is when the circuit last opened. Zero if it never did
+	OpenedAt  time.Time
+	LastError string
+}
+
+// NewCircuitBreaker creates a closed circuit breaker. Non positive settings fall back to their defaults
+func NewCircuitBreaker(name string, failureThreshold int, openTimeout time.Duration) *CircuitBreaker {
+	if failureThreshold <= 0 {
+		failureThreshold = DefaultFailureThreshold
+	}
+	if openTimeout <= 0 {
+		openTimeout = DefaultOpenTimeout
+	}
+	return &CircuitBreaker{Name: name, FailureThreshold: failureThreshold, OpenTimeout: openTimeout}
+}
+
+// State gets the current state of the circuit
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.currentState(time.Now())
+}
+
+// Status gets a snapshot of the circuit breaker
+func (b *CircuitBreaker) Status() CircuitStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := CircuitStatus{
+		Name:                b.Name,
+		State:               b.currentState(time.Now()),
+		ConsecutiveFailures: b.failures,
+		OpenedAt:            b.openedAt,
+	}
+	if b.lastErr != nil {
+		status.LastError = b.lastErr.Error()
+	}
+	return status
+}
+
+// currentState moves an open circuit to half-open once its timeout has passed
+func (b *CircuitBreaker) currentState(now time.Time) CircuitState {
+	if b.state == CircuitOpen && now.Sub(b.openedAt) >= b.OpenTimeout {
+		b.state = CircuitHalfOpen
+	}
+	return b.state
+}
+
+// allow checks whether a call may go through. A half-open circuit lets a single trial call through at a time
+func (b *CircuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.currentState(time.Now()) {
+	case CircuitOpen:
+		return fmt.Errorf("%w: %s is unavailable since %s", ErrCircuitOpen, b.Name, b.openedAt.Format(time.RFC3339))
+	case CircuitHalfOpen:
+		if b.trial {
+			return fmt.Errorf("%w: %s is being checked", ErrCircuitOpen, b.Name)
+		}
+		b.trial = true
+	}
+	return nil
+}
+
+// record updates the circuit with the outcome of a call. A nil error is a success
+func (b *CircuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasTrial := b.trial
+	b.trial = false
+
+	if err == nil {
+		if b.state != CircuitClosed {
+			log.Printf("Circuit of %s closed, the upstream is back", b.Name)
+		}
+		b.state = CircuitClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	b.lastErr = err
+	if wasTrial || (b.state == CircuitClosed && b.failures >= b.FailureThreshold) {
+		log.Printf("Circuit of %s opened after %d consecutive failures: %v", b.Name, b.failures, err)
+		b.state = CircuitOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Transport wraps a round tripper so calls are skipped while the circuit is open. A nil breaker lets every call
+// through
+func (b *CircuitBreaker) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if b == nil {
+		return base
+	}
+	return &circuitBreakerTransport{breaker: b, base: base}
+}
+
+type circuitBreakerTransport struct {
+	breaker *CircuitBreaker
+	base    http.RoundTripper
+}
+
+func (t *circuitBreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		// the caller gave up, which says nothing about the upstream
+		t.breaker.mu.Lock()
+		t.breaker.trial = false
+		t.breaker.mu.Unlock()
+	case err != nil:
+		t.breaker.record(err)
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout:
+		t.breaker.record(fmt.Errorf("received %d %s", resp.StatusCode, http.StatusText(resp.StatusCode)))
+	default:
+		t.breaker.record(nil)
+	}
+	return resp, err
+}
+
+// CircuitBreakers groups the circuit breakers of every upstream
+type CircuitBreakers []*CircuitBreaker
+
+// Statuses gets a snapshot of every circuit breaker
+func (c CircuitBreakers) Statuses() []CircuitStatus {
+	statuses := make([]CircuitStatus, len(c))
+	for i, breaker := range c {
+		statuses[i] = breaker.Status()
+	}
+	return statuses
+}
+
+// Degraded tells whether some upstream isn't fully available, its circuit being open or half-open
+func (c CircuitBreakers) Degraded() bool {
+	for _, breaker := range c {
+		if breaker.State() != CircuitClosed {
+			return true
+		}
+	}
+	return false
+}
+
+// newHttpClient creates the client of an upstream, going through its circuit breaker, then its rate limiter and
+// then the fixtures, if any. Calls skipped by the breaker don't take a token from the limiter
+func newHttpClient(timeout time.Duration, breaker *CircuitBreaker, limiter *RateLimiter, fixtures *Fixtures) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: breaker.Transport(limiter.Transport(fixtures.Transport(nil)))}
+}
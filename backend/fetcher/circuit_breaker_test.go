@@ -0,0 +1,138 @@
+package fetcher
+
+import (
+	"context"
+	"github.com/c4ts0up/my-stocks/backend/models"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// --- TEST CASE 1: Consecutive failures open the circuit, and calls are skipped while it is open ---
+func TestCircuitBreaker_OpensAfterFailures(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	breaker := NewCircuitBreaker("info", 3, time.Minute)
+	client := newHttpClient(0, breaker, nil, nil)
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(server.URL)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+	assert.Equal(t, CircuitOpen, breaker.State())
+
+	_, err := client.Get(server.URL)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	status := breaker.Status()
+	assert.Equal(t, 3, status.ConsecutiveFailures)
+	assert.Equal(t, "received 500 Internal Server Error", status.LastError)
+	assert.False(t, status.OpenedAt.IsZero())
+}
+
+// --- TEST CASE 2: A success resets the count of consecutive failures ---
+func TestCircuitBreaker_SuccessResetsFailures(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1)%2 == 0 {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	breaker := NewCircuitBreaker("info", 2, time.Minute)
+	client := newHttpClient(0, breaker, nil, nil)
+
+	for i := 0; i < 6; i++ {
+		resp, err := client.Get(server.URL)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+	assert.Equal(t, CircuitClosed, breaker.State())
+}
+
+// --- TEST CASE 3: After the open timeout a trial call is let through, closing the circuit on success or opening it again ---
+func TestCircuitBreaker_HalfOpen(t *testing.T) {
+	var healthy atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if healthy.Load() {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	breaker := NewCircuitBreaker("ratings", 1, 20*time.Millisecond)
+	client := newHttpClient(0, breaker, nil, nil)
+
+	resp, _ := client.Get(server.URL)
+	_ = resp.Body.Close()
+	assert.Equal(t, CircuitOpen, breaker.State())
+
+	// the trial call fails, so the circuit opens again
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, CircuitHalfOpen, breaker.State())
+	resp, _ = client.Get(server.URL)
+	_ = resp.Body.Close()
+	assert.Equal(t, CircuitOpen, breaker.State())
+
+	// the trial call succeeds, so the circuit closes
+	healthy.Store(true)
+	time.Sleep(30 * time.Millisecond)
+	resp, err := client.Get(server.URL)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, CircuitClosed, breaker.State())
+	assert.Equal(t, 0, breaker.Status().ConsecutiveFailures)
+}
+
+// --- TEST CASE 4: While the info API circuit is open, FetchAllInfo skips every call and records the tickers as failed ---
+func TestCircuitBreaker_FetchAllInfoSkipsCalls(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	fetcher := BasicStockInfoFetcher{
+		DB:      models.NewTestDB(nil),
+		Breaker: NewCircuitBreaker("info", 2, time.Minute),
+		Workers: 1,
+	}
+
+	report, err := fetcher.FetchAllInfo(context.Background(), []string{"AAPL", "MSFT", "GOOGL", "AMZN"}, server.URL)
+	assert.NoError(t, err)
+	assert.Len(t, report.FailedTickers, 4)
+	assert.Equal(t, 2, countErrors(report.FailedTickers, ErrCircuitOpen))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+// --- TEST CASE 5: Upstreams are degraded while any circuit isn't closed ---
+func TestCircuitBreakers_Degraded(t *testing.T) {
+	info := NewCircuitBreaker("info", 1, time.Minute)
+	breakers := CircuitBreakers{NewCircuitBreaker("ratings", 1, time.Minute), info}
+	assert.False(t, breakers.Degraded())
+
+	info.record(assert.AnError)
+	assert.True(t, breakers.Degraded())
+	statuses := breakers.Statuses()
+	assert.Equal(t, CircuitClosed, statuses[0].State)
+	assert.Equal(t, CircuitOpen, statuses[1].State)
+
+	var none CircuitBreakers
+	assert.False(t, none.Degraded())
+}
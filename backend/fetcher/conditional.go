@@ -0,0 +1,56 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"github.com/c4ts0up/my-stocks/backend/models"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+)
+
+// ErrNotModified is returned when the upstream answers a conditional request with 304 Not Modified, meaning the
+// saved data is still up to date
+var ErrNotModified = errors.New("not modified")
+
+// loadValidator gets the validators saved for the URL. A failure is logged and the request is simply made
+// unconditional
+func loadValidator(ctx context.Context, db *gorm.DB, url string) models.ResponseValidator {
+	validator, err := models.GetResponseValidator(ctx, db, url)
+	if err != nil {
+		log.Printf("Failed to load the validators of %s, requesting the full response: %v", url, err)
+		return models.ResponseValidator{URL: url}
+	}
+	return validator
+}
+
+// setConditionalHeaders makes the request conditional on the response having changed since the validators were saved
+func setConditionalHeaders(req *http.Request, validator models.ResponseValidator) {
+	if validator.ETag != "" {
+		req.Header.Set("If-None-Match", validator.ETag)
+	}
+	if validator.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validator.LastModified)
+	}
+}
+
+// responseValidator reads the validators of a response. Returns nil when the response has none
+func responseValidator(url string, resp *http.Response, nextPage string) *models.ResponseValidator {
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return nil
+	}
+	return &models.ResponseValidator{URL: url, ETag: etag, LastModified: lastModified, NextPage: nextPage}
+}
+
+// saveValidator saves the validators of a response once its data is saved. A failure is only logged, as it just
+// makes the next request unconditional
+func saveValidator(ctx context.Context, db *gorm.DB, validator *models.ResponseValidator) {
+	if validator == nil {
+		return
+	}
+	if err := models.SaveResponseValidator(ctx, db, *validator); err != nil {
+		log.Printf("Failed to save the validators of %s: %v", validator.URL, err)
+	}
+}
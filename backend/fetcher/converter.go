@@ -1,33 +1,46 @@
 package fetcher
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/c4ts0up/my-stocks/backend/models"
+	"log"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 // convertStockRatingsApiResponse converts StockRatingResponse to StockRating
 func convertStockRatingsApiResponse(resp models.StockRatingRaw) (models.StockRating, error) {
-	// Strip dollar signs and convert to float
-	targetFrom, err := parseDollarValue(resp.TargetFrom)
+	ticker, err := models.NormalizeTicker(resp.Ticker)
 	if err != nil {
 		return models.StockRating{}, err
 	}
 
-	targetTo, err := parseDollarValue(resp.TargetTo)
+	// Strip currency symbols and convert to float
+	targetFrom, fromCurrency, err := parseMoneyValue(resp.TargetFrom)
 	if err != nil {
 		return models.StockRating{}, err
 	}
 
+	targetTo, toCurrency, err := parseMoneyValue(resp.TargetTo)
+	if err != nil {
+		return models.StockRating{}, err
+	}
+
+	if fromCurrency != toCurrency {
+		return models.StockRating{}, fmt.Errorf("targets in different currencies, %s and %s", fromCurrency, toCurrency)
+	}
+
 	// Parse time from string to time.Time
 	parsedTime, err := time.Parse(time.RFC3339Nano, resp.Time)
 	if err != nil {
 		return models.StockRating{}, err
 	}
 
-	return models.StockRating{
-		Ticker:     resp.Ticker,
+	rating := models.StockRating{
+		Ticker:     ticker,
 		TargetFrom: targetFrom,
 		TargetTo:   targetTo,
 		Action:     resp.Action,
@@ -35,12 +48,199 @@ func convertStockRatingsApiResponse(resp models.StockRatingRaw) (models.StockRat
 		RatingFrom: resp.RatingFrom,
 		RatingTo:   resp.RatingTo,
 		Time:       parsedTime,
-	}, nil
+		Currency:   toCurrency,
+		Company:    strings.TrimSpace(resp.Company),
+	}
+
+	// ratings are keyed by their upstream ID when there is one, once their source is known, and by their content
+	// otherwise
+	if resp.ID != "" {
+		rating.UpstreamID = resp.ID
+	} else {
+		rating.RevisionKey = models.StockRatingContentKey(rating)
+	}
+
+	return rating, nil
+}
+
+// convertStockRatingsPage converts the raw rows of a page. Rows that can't be converted are left out of the page and
+// listed as skipped, labeled after the page they come from
+func convertStockRatingsPage(label string, rows []models.StockRatingRaw, nextPage string) StockRatingsPage {
+	page := StockRatingsPage{NextPage: nextPage}
+	for i, rawStock := range rows {
+		stock, err := convertStockRatingsApiResponse(rawStock)
+		if err != nil {
+			page.reject(label, i, rawStock.Ticker, models.RatingFormatApi, rawStock, err)
+			continue
+		}
+		page.Ratings = append(page.Ratings, stock)
+	}
+	return page
+}
+
+// keyBySource keys the ratings the upstream gave an ID to by that ID, namespaced by the name of the source they come
+// from, as IDs are only unique within a source
+func keyBySource(source string, ratings []models.StockRating) {
+	for i := range ratings {
+		if ratings[i].UpstreamID != "" {
+			ratings[i].RevisionKey = models.StockRatingUpstreamKey(source, ratings[i].UpstreamID)
+		}
+	}
 }
 
-// parseDollarValue converts "$4.20" -> 4.20. It follows USA's money convention (, for 000's, . dor decimals)
-func parseDollarValue(value string) (float64, error) {
-	cleanValue := strings.ReplaceAll(value, "$", "")
-	cleanValue = strings.ReplaceAll(cleanValue, ",", "")
-	return strconv.ParseFloat(cleanValue, 64)
+// reject leaves a row that couldn't be converted out of the page. The row is listed as skipped and kept as it was
+// received, to be quarantined
+func (p *StockRatingsPage) reject(label string, i int, ticker string, format string, raw any, err error) {
+	err = fmt.Errorf("failed to parse stock data, got %w", err)
+	p.Skipped = append(p.Skipped, ItemError{
+		Item: fmt.Sprintf("%s row %d (%s)", label, i, ticker),
+		Err:  err,
+	})
+
+	rawJson, marshalErr := json.Marshal(raw)
+	if marshalErr != nil {
+		return
+	}
+	p.Quarantined = append(p.Quarantined, models.QuarantinedRating{
+		Page:    label,
+		Row:     i,
+		Format:  format,
+		Ticker:  ticker,
+		RawJSON: string(rawJson),
+		Error:   err.Error(),
+	})
+}
+
+// currencySymbols maps the currency symbols found in money strings to their ISO codes. Longer symbols go first, so
+// "C$" isn't taken for "$"
+var currencySymbols = []struct {
+	symbol string
+	code   string
+}{
+	{"US$", "USD"}, {"HK$", "HKD"}, {"C$", "CAD"}, {"A$", "AUD"}, {"R$", "BRL"},
+	{"$", "USD"}, {"€", "EUR"}, {"£", "GBP"}, {"¥", "JPY"}, {"₹", "INR"}, {"₩", "KRW"},
+}
+
+// parseMoneyValue converts "$4.20" -> 4.20 USD, "€12.50" -> 12.50 EUR, "£3,100" -> 3100 GBP or "12.50 CHF" -> 12.50 CHF.
+// It follows USA's money convention (, for 000's, . for decimals). Amounts without a currency are in USD
+func parseMoneyValue(value string) (float64, string, error) {
+	cleanValue := strings.TrimSpace(value)
+	currency := ""
+
+	if code, rest, ok := cutCurrencyCode(cleanValue); ok {
+		currency, cleanValue = code, rest
+	}
+	for _, s := range currencySymbols {
+		if rest, ok := strings.CutPrefix(cleanValue, s.symbol); ok {
+			currency, cleanValue = s.code, rest
+			break
+		}
+		if rest, ok := strings.CutSuffix(cleanValue, s.symbol); ok {
+			currency, cleanValue = s.code, rest
+			break
+		}
+	}
+	if currency == "" {
+		currency = models.DefaultCurrency
+	}
+
+	cleanValue = strings.ReplaceAll(strings.TrimSpace(cleanValue), ",", "")
+	amount, err := strconv.ParseFloat(cleanValue, 64)
+	if err != nil {
+		return 0, "", err
+	}
+	return amount, currency, nil
+}
+
+// cutCurrencyCode cuts the ISO currency code a money string starts or ends with, as in "EUR 12.50" or "12.50 EUR"
+func cutCurrencyCode(value string) (string, string, bool) {
+	if len(value) <= 3 {
+		return "", value, false
+	}
+	if isCurrencyCode(value[:3]) {
+		return value[:3], value[3:], true
+	}
+	if isCurrencyCode(value[len(value)-3:]) {
+		return value[len(value)-3:], value[:len(value)-3], true
+	}
+	return "", value, false
+}
+
+// isCurrencyCode tells whether the value looks like an ISO currency code, three upper case letters
+func isCurrencyCode(value string) bool {
+	for _, r := range value {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return len(value) == 3
+}
+
+// convertStockInfoApiResponse converts StockInfoRaw to Stock
+func convertStockInfoApiResponse(resp models.StockInfoRaw) models.Stock {
+	return models.Stock{
+		Ticker:     resp.Ticker,
+		Company:    resp.CompanyName,
+		LastPrice:  resp.LastPrice,
+		Open:       resp.Open,
+		LastClose:  resp.LastClose,
+		Percentage: resp.Percentage,
+		Currency:   currencyCode(resp.Currency),
+	}
+}
+
+// convertStructuredRating converts StructuredRatingRaw to StockRating
+func convertStructuredRating(resp models.StructuredRatingRaw) (models.StockRating, error) {
+	ticker, err := models.NormalizeTicker(resp.Symbol)
+	if err != nil {
+		return models.StockRating{}, err
+	}
+
+	parsedTime, err := time.Parse(time.RFC3339Nano, resp.PublishedAt)
+	if err != nil {
+		return models.StockRating{}, err
+	}
+
+	rating := models.StockRating{
+		Ticker:     ticker,
+		TargetFrom: resp.PriceTarget.From,
+		TargetTo:   resp.PriceTarget.To,
+		Action:     resp.Action,
+		Brokerage:  resp.Firm,
+		RatingFrom: resp.Rating.From,
+		RatingTo:   resp.Rating.To,
+		Time:       parsedTime,
+		Currency:   currencyCode(resp.PriceTarget.Currency),
+	}
+
+	if resp.ID != "" {
+		rating.UpstreamID = resp.ID
+	} else {
+		rating.RevisionKey = models.StockRatingContentKey(rating)
+	}
+
+	return rating, nil
+}
+
+// currencyCode normalizes the currency code given by an upstream, defaulting to USD
+func currencyCode(currency string) string {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		return models.DefaultCurrency
+	}
+	return currency
+}
+
+// unknownActions remembers the action phrases that couldn't be typed, so each is logged for review only once
+var unknownActions sync.Map
+
+// classifyRatingAction types the action of a rating, logging the phrases that aren't understood
+func classifyRatingAction(rating models.StockRating) models.RatingActionType {
+	actionType, ok := models.ClassifyRatingAction(rating.Action, rating.TargetFrom, rating.TargetTo)
+	if !ok {
+		if _, seen := unknownActions.LoadOrStore(rating.Action, true); !seen {
+			log.Printf("Unknown rating action %q (%s by %s), review its type", rating.Action, rating.Ticker, rating.Brokerage)
+		}
+	}
+	return actionType
 }
@@ -0,0 +1,67 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/c4ts0up/my-stocks/backend/models"
+	"gorm.io/gorm"
+	"log"
+)
+
+// maxRecordedProblems bounds the problems kept in the record of a run, as a broken upstream can fail every item
+const maxRecordedProblems = 100
+
+// RecordFetchRun runs a fetch and records it in the fetch runs, from its start to its outcome. A failure to record
+// the run is only logged, the fetch goes on regardless. The end of the run is recorded even if the context is done
+func RecordFetchRun(ctx context.Context, db *gorm.DB, fetch func(ctx context.Context) (*FetchReport, error)) (models.FetchRun, *FetchReport, error) {
+	run, recordErr := models.StartFetchRun(ctx, db)
+	if recordErr != nil {
+		log.Printf("Failed to record the start of the fetch run: %v", recordErr)
+	}
+
+	report, err := fetch(ctx)
+	if report == nil {
+		report = &FetchReport{}
+	}
+	report.fillRun(&run, err)
+
+	if recordErr == nil {
+		if err := models.FinishFetchRun(context.WithoutCancel(ctx), db, &run); err != nil {
+			log.Printf("Failed to record the end of fetch run %d: %v", run.ID, err)
+		}
+	}
+	return run, report, err
+}
+
+// fillRun fills the record of a run with the counts of the report and its outcome
+func (r *FetchReport) fillRun(run *models.FetchRun, runErr error) {
+	run.PagesFetched = len(r.SucceededPages)
+	run.PagesUnchanged = len(r.UnchangedPages)
+	run.PagesFailed = len(r.FailedPages)
+	run.RatingsSaved = r.SavedRatings
+	run.RatingsSkipped = len(r.SkippedRatings)
+	run.TickersRefreshed = len(r.SucceededTickers)
+	run.TickersUnchanged = len(r.UnchangedTickers)
+	run.TickersSkipped = len(r.SkippedTickers)
+	run.TickersFailed = len(r.FailedTickers)
+
+	switch {
+	case runErr != nil:
+		run.Outcome = models.FetchRunFailed
+		run.Error = runErr.Error()
+	case r.HasFailures():
+		run.Outcome = models.FetchRunPartial
+	default:
+		run.Outcome = models.FetchRunSucceeded
+	}
+
+	problems := r.Problems()
+	run.ProblemCount = len(problems)
+	messages := make([]string, 0, min(len(problems), maxRecordedProblems))
+	for _, problem := range problems[:min(len(problems), maxRecordedProblems)] {
+		messages = append(messages, problem.Error())
+	}
+	if problemsJson, err := json.Marshal(messages); err == nil {
+		run.ProblemsJSON = string(problemsJson)
+	}
+}
@@ -0,0 +1,69 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"github.com/c4ts0up/my-stocks/backend/models"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// --- TEST CASE 1: A fetch run is recorded from its start to its outcome ---
+func TestRecordFetchRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"items": [
+			{"ticker": "BSBR", "target_from": "$4.20", "target_to": "$4.70", "brokerage": "A", "time": "2025-01-13T00:30:05Z"},
+			{"ticker": "BSBR", "target_from": "$4.20", "target_to": "$4.90", "brokerage": "B", "time": "2025-01-13T00:30:05Z"},
+			{"ticker": "NA", "target_from": "N/A", "target_to": "$4.70", "brokerage": "A", "time": "2025-01-13T00:30:05Z"}
+		], "next_page": ""}`))
+	}))
+	defer server.Close()
+
+	db := models.NewTestDB(nil)
+	ratingsFetcher := BasicStockRatingsFetcher{DB: db, BearerToken: mockTocken}
+
+	run, report, err := RecordFetchRun(context.Background(), db, func(ctx context.Context) (*FetchReport, error) {
+		_, report, err := ratingsFetcher.FetchAllRatings(ctx, server.URL)
+		return report, err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.SavedRatings)
+
+	var recorded models.FetchRun
+	assert.NoError(t, db.First(&recorded, run.ID).Error)
+	assert.Equal(t, models.FetchRunSucceeded, recorded.Outcome)
+	assert.NotNil(t, recorded.FinishedAt)
+	assert.Equal(t, 1, recorded.PagesFetched)
+	assert.Equal(t, 2, recorded.RatingsSaved)
+	assert.Equal(t, 1, recorded.RatingsSkipped)
+	assert.Equal(t, 1, recorded.ProblemCount)
+	assert.Contains(t, recorded.ProblemsJSON, "row 2 (NA)")
+}
+
+// --- TEST CASE 2: The outcome tells apart failed stages from failed items ---
+func TestRecordFetchRun_Outcomes(t *testing.T) {
+	db := models.NewTestDB(nil)
+
+	run, _, err := RecordFetchRun(context.Background(), db, func(ctx context.Context) (*FetchReport, error) {
+		return &FetchReport{SucceededTickers: []string{"AAPL"}, FailedTickers: []ItemError{{Item: "MSFT", Err: errors.New("boom")}}}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, models.FetchRunPartial, run.Outcome)
+	assert.Equal(t, 1, run.TickersRefreshed)
+	assert.Equal(t, 1, run.TickersFailed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run, _, err = RecordFetchRun(ctx, db, func(ctx context.Context) (*FetchReport, error) {
+		cancel()
+		return nil, ctx.Err()
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// the end of the run is recorded even though the run was cancelled
+	var recorded models.FetchRun
+	assert.NoError(t, db.First(&recorded, run.ID).Error)
+	assert.Equal(t, models.FetchRunFailed, recorded.Outcome)
+	assert.Equal(t, context.Canceled.Error(), recorded.Error)
+}
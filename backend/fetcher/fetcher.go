@@ -1,5 +1,10 @@
 package fetcher
 
+import (
+	"context"
+	"errors"
+)
+
 // TODO: fetcher for stock ratings
 // TODO: fetcher for stock info
 // TODO: full fetcher interface
@@ -10,24 +15,42 @@ type StockFetcher struct {
 }
 
 type IStockRatingsFetcher interface {
-	FetchAllRatings(url string) ([]string, error)
+	FetchAllRatings(ctx context.Context, url string) ([]string, *FetchReport, error)
 }
 
 type IStockInfoFetcher interface {
-	FetchAllInfo(tickers []string, url string) error
+	FetchAllInfo(ctx context.Context, tickers []string, url string) (*FetchReport, error)
+}
+
+// Stages of a fetch run
+const (
+	StageRatings = "ratings"
+	StageInfo    = "info"
+)
+
+// FetchAll fetches the ratings and then the info of every rated ticker. Failures of single pages or tickers are
+// recorded in the report and don't stop the run; the returned error tells if a whole stage couldn't complete.
+// The context bounds the whole run, down to every request and database write
+func (f *StockFetcher) FetchAll(ctx context.Context, ratingsUrl string, infoUrl string) (*FetchReport, error) {
+	return f.FetchAllStages(ctx, ratingsUrl, infoUrl, nil)
 }
 
-func (f *StockFetcher) FetchAll(ratingsUrl string, infoUrl string) error {
-	// fetches rating
-	tickers, err := f.RatingsFetcher.FetchAllRatings(ratingsUrl)
-	if err != nil {
-		return err
+// FetchAllStages is FetchAll, calling onStage, if any, as each stage starts along with the amount of items it covers
+func (f *StockFetcher) FetchAllStages(ctx context.Context, ratingsUrl string, infoUrl string, onStage func(stage string, items int)) (*FetchReport, error) {
+	if onStage == nil {
+		onStage = func(string, int) {}
 	}
+	report := &FetchReport{}
+
+	// fetches rating. Even if the pagination breaks, the tickers from the pages already fetched are refreshed
+	onStage(StageRatings, 0)
+	tickers, ratingsReport, ratingsErr := f.RatingsFetcher.FetchAllRatings(ctx, ratingsUrl)
+	report.Merge(ratingsReport)
+
 	// fetches info
-	err = f.InfoFetcher.FetchAllInfo(tickers, infoUrl)
-	if err != nil {
-		return err
-	}
+	onStage(StageInfo, len(tickers))
+	infoReport, infoErr := f.InfoFetcher.FetchAllInfo(ctx, tickers, infoUrl)
+	report.Merge(infoReport)
 
-	return nil
+	return report, errors.Join(ratingsErr, infoErr)
 }
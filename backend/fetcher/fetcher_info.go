@@ -1,7 +1,9 @@
 package fetcher
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/c4ts0up/my-stocks/backend/models"
 	"gorm.io/gorm"
@@ -9,92 +11,394 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 )
 
+// DefaultInfoWorkers is the number of concurrent info requests used when none is configured
+const DefaultInfoWorkers = 8
+
 type BasicStockInfoFetcher struct {
-	DB          *gorm.DB
+	DB *gorm.DB
+	// Auth authenticates the requests to the info API. Defaults to sending BearerToken as a bearer token, if any
+	Auth        IAuthenticator
 	BearerToken string
+	Retry       RetryPolicy
+	// RequestTimeout bounds each request to the info API, including reading its body. Zero means no timeout
+	RequestTimeout time.Duration
+	// Limiter throttles the requests to the info API. It is usually shared with the other fetchers. Nil means no limit
+	Limiter *RateLimiter
+	// Breaker skips the requests to the info API while it is down. Nil means requests are always sent
+	Breaker *CircuitBreaker
+	// ConditionalRequests makes FetchAllInfo send the validators of the last saved response of each request, so
+	// unchanged quotes are neither downloaded nor saved again
+	ConditionalRequests bool
+	// Workers caps the number of concurrent requests made by FetchAllInfo. Defaults to DefaultInfoWorkers
+	Workers int
+	// BatchSize is the number of tickers sent in each request by FetchAllInfo. Values below 2 request one ticker at a time
+	BatchSize int
+	// TickerSeparator separates the share class in the tickers sent to the info API, such as "-" for BRK-B.
+	// Defaults to the dot of the canonical tickers
+	TickerSeparator string
+	// Fixtures records the traffic of the info API, or replays it instead of calling the API. Nil means neither
+	Fixtures *Fixtures
 }
 
-// FetchStockInfo fetches stock data from Algobook Stock API
-func (b *BasicStockInfoFetcher) FetchStockInfo(ticker string, baseUrl string) (models.Stock, error) {
+// ErrNoStockInfo is returned when the info API has no data for a ticker
+var ErrNoStockInfo = errors.New("no data returned")
+
+// StockInfoBatch holds the stocks returned for a batch of tickers, along with the tickers missing from the response
+type StockInfoBatch struct {
+	Stocks  []models.Stock
+	Missing []string
+	// Validator holds the validators of the response, to be saved along with its stocks. Nil if there are none
+	Validator *models.ResponseValidator
+}
+
+// fetchStockInfoRows queries the Algobook Stock API for the given tickers and returns the raw rows along with the
+// validators of the response. With ConditionalRequests, ErrNotModified is returned when the response didn't change
+// since it was last saved
+func (b *BasicStockInfoFetcher) fetchStockInfoRows(ctx context.Context, tickers []string, baseUrl string) (models.StockInfoQueryResponse, *models.ResponseValidator, error) {
 	// Parse the base URL
 	u, err := url.Parse(baseUrl)
 	if err != nil {
-		return models.Stock{}, fmt.Errorf("invalid base URL: %w", err)
+		return nil, nil, fmt.Errorf("invalid base URL: %w", err)
 	}
 
-	// Add the ticker as a query parameter
+	// Add the tickers as a query parameter, in the convention of the info API
+	vendorTickers := make([]string, len(tickers))
+	for i, ticker := range tickers {
+		vendorTickers[i] = models.VendorTicker(ticker, b.TickerSeparator)
+	}
+	joinedTickers := strings.Join(vendorTickers, ",")
 	q := u.Query()
-	q.Set("tickers", ticker)
+	q.Set("tickers", joinedTickers)
 	u.RawQuery = q.Encode()
 
 	log.Printf("Fetching stock info from %s", u.String())
 
-	resp, err := http.Get(u.String())
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if b.ConditionalRequests {
+		setConditionalHeaders(req, loadValidator(ctx, b.DB, u.String()))
+	}
+
+	auth := b.Auth
+	if auth == nil && b.BearerToken != "" {
+		auth = &BearerAuth{Tokens: NewTokenRotation([]string{b.BearerToken})}
+	}
+
+	resp, err := b.Retry.Do(newHttpClient(b.RequestTimeout, b.Breaker, b.Limiter, b.Fixtures), req, auth)
 	if err != nil {
-		return models.Stock{}, fmt.Errorf("failed to fetch data for %s: %w", ticker, err)
+		return nil, nil, fmt.Errorf("failed to fetch data for %s: %w", joinedTickers, err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && b.ConditionalRequests {
+		return nil, nil, fmt.Errorf("%w for %s", ErrNotModified, joinedTickers)
+	}
 	if resp.StatusCode != http.StatusOK {
-		return models.Stock{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return models.Stock{}, fmt.Errorf("failed to read response body: %w", err)
+		return nil, nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
 	var data models.StockInfoQueryResponse
 	if err := json.Unmarshal(body, &data); err != nil {
-		return models.Stock{}, fmt.Errorf("failed to parse JSON: %w", err)
+		return nil, nil, fmt.Errorf("failed to parse JSON: %w", err)
 	}
 
-	if len(data) == 0 {
-		return models.Stock{}, fmt.Errorf("no data returned for ticker %s", ticker)
+	var validator *models.ResponseValidator
+	if b.ConditionalRequests {
+		validator = responseValidator(u.String(), resp, "")
+	}
+	return data, validator, nil
+}
+
+// FetchStockInfo fetches stock data from Algobook Stock API
+func (b *BasicStockInfoFetcher) FetchStockInfo(ctx context.Context, ticker string, baseUrl string) (models.Stock, error) {
+	stock, _, err := b.fetchStockInfo(ctx, ticker, baseUrl)
+	return stock, err
+}
+
+// fetchStockInfo fetches the stock data of a ticker along with the validators of the response
+func (b *BasicStockInfoFetcher) fetchStockInfo(ctx context.Context, ticker string, baseUrl string) (models.Stock, *models.ResponseValidator, error) {
+	data, validator, err := b.fetchStockInfoRows(ctx, []string{ticker}, baseUrl)
+	if err != nil {
+		return models.Stock{}, nil, err
 	}
 
-	// Extract stock details
-	stock := models.Stock{
-		Ticker:    data[0].Ticker,
-		Company:   data[0].CompanyName,
-		LastPrice: data[0].LastPrice,
+	if len(data) == 0 {
+		return models.Stock{}, nil, fmt.Errorf("%w for ticker %s", ErrNoStockInfo, ticker)
 	}
 
-	return stock, nil
+	// the stock is saved under the requested ticker, whichever convention the info API answers in
+	stock := convertStockInfoApiResponse(data[0])
+	stock.Ticker = ticker
+	return stock, validator, nil
 }
 
-// SaveStockInfo saves a Stock model to the database
-func (b *BasicStockInfoFetcher) SaveStockInfo(stock models.Stock) error {
-	result := b.DB.Model(&models.Stock{}).Where("ticker = ?", stock.Ticker).Updates(models.Stock{
-		LastPrice: stock.LastPrice,
-		Company:   stock.Company,
-	})
+// FetchStockInfoBatch fetches the stock data of several tickers in a single request. The returned rows are matched
+// back to the requested tickers by their normalized ticker, and saved under the requested one. Rows for tickers that
+// were not requested are ignored, and requested tickers without a row are reported as missing
+func (b *BasicStockInfoFetcher) FetchStockInfoBatch(ctx context.Context, tickers []string, baseUrl string) (StockInfoBatch, error) {
+	data, validator, err := b.fetchStockInfoRows(ctx, tickers, baseUrl)
+	if err != nil {
+		return StockInfoBatch{}, err
+	}
+
+	rowsByTicker := make(map[string]models.StockInfoRaw, len(data))
+	for _, row := range data {
+		rowsByTicker[tickerKey(row.Ticker)] = row
+	}
 
-	// If no rows were affected, it's a new stock, so we insert it
-	if result.RowsAffected == 0 {
-		if err := b.DB.Create(&stock).Error; err != nil {
-			return fmt.Errorf("failed to insert new stock data for %s: %w", stock.Ticker, err)
+	batch := StockInfoBatch{Validator: validator}
+	for _, ticker := range tickers {
+		row, ok := rowsByTicker[tickerKey(ticker)]
+		if !ok {
+			batch.Missing = append(batch.Missing, ticker)
+			continue
 		}
+		stock := convertStockInfoApiResponse(row)
+		stock.Ticker = ticker
+		batch.Stocks = append(batch.Stocks, stock)
 	}
 
-	return nil
+	return batch, nil
+}
+
+// SaveStockInfo saves a Stock model to the database, along with a snapshot of its quote for the price history
+func (b *BasicStockInfoFetcher) SaveStockInfo(ctx context.Context, stock models.Stock) error {
+	return b.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Stock{}).Where("ticker = ?", stock.Ticker).Updates(models.Stock{
+			LastPrice: stock.LastPrice,
+			Currency:  stock.Currency,
+			Company:   stock.Company,
+		})
+		if result.Error != nil {
+			return fmt.Errorf("failed to update stock data for %s: %w", stock.Ticker, result.Error)
+		}
+
+		// If no rows were affected, it's a new stock, so we insert it
+		if result.RowsAffected == 0 {
+			if err := tx.Create(&stock).Error; err != nil {
+				return fmt.Errorf("failed to insert new stock data for %s: %w", stock.Ticker, err)
+			}
+		} else {
+			// the rest of the quote is updated even when zero, a flat day is a valid quote
+			err := tx.Model(&models.Stock{}).Where("ticker = ?", stock.Ticker).
+				Select("open", "last_close", "percentage").
+				Updates(models.Stock{Open: stock.Open, LastClose: stock.LastClose, Percentage: stock.Percentage}).Error
+			if err != nil {
+				return fmt.Errorf("failed to update stock data for %s: %w", stock.Ticker, err)
+			}
+		}
+
+		snapshot := models.NewPriceSnapshot(stock, time.Now())
+		if err := tx.Create(&snapshot).Error; err != nil {
+			return fmt.Errorf("failed to save the price snapshot of %s: %w", stock.Ticker, err)
+		}
+		return nil
+	})
 }
 
-// FetchAllInfo fetches and saves data for all given tickers
-func (b *BasicStockInfoFetcher) FetchAllInfo(tickers []string, url string) error {
+// FetchAllInfo fetches and saves data for all given tickers. Requests run concurrently on a bounded pool of
+// workers, but stocks are saved in the order of the given tickers, so the result matches a sequential run.
+// A failing ticker doesn't stop the run, it is recorded in the returned report. Cancelling the context does,
+// and the context's error is returned. Tickers skipped because the circuit of the info API is open are recorded
+// as failed, but logged only once. Tickers are normalized first, the invalid ones are skipped and each ticker is
+// fetched once, so it gets a single price snapshot per run
+func (b *BasicStockInfoFetcher) FetchAllInfo(ctx context.Context, tickers []string, url string) (*FetchReport, error) {
+	report := &FetchReport{}
 
+	normalized := make([]string, 0, len(tickers))
 	for _, ticker := range tickers {
-		stock, err := b.FetchStockInfo(ticker, url)
+		canonical, err := models.NormalizeTicker(ticker)
 		if err != nil {
-			return fmt.Errorf("failed to fetch data for ticker %s: %w", ticker, err)
+			report.SkippedTickers = append(report.SkippedTickers, ItemError{Item: ticker, Err: err})
+			continue
 		}
+		normalized = append(normalized, canonical)
+	}
+	tickers = uniqueTickers(normalized)
+
+	var err error
+	if b.BatchSize > 1 {
+		err = b.fetchAllInfoBatched(ctx, tickers, url, report)
+	} else {
+		err = b.fetchAllInfoSingly(ctx, tickers, url, report)
+	}
+
+	if skipped := countErrors(report.FailedTickers, ErrCircuitOpen); skipped > 0 {
+		log.Printf("Skipped %d tickers, the info API is unavailable", skipped)
+	}
+	return report, err
+}
+
+// fetchAllInfoSingly fetches and saves data for all given tickers, sending a request per ticker
+func (b *BasicStockInfoFetcher) fetchAllInfoSingly(ctx context.Context, tickers []string, url string, report *FetchReport) error {
+	type fetchedStock struct {
+		stock     models.Stock
+		validator *models.ResponseValidator
+	}
+
+	return runOrdered(len(tickers), b.workers(),
+		func(i int) (fetchedStock, error) {
+			stock, validator, err := b.fetchStockInfo(ctx, tickers[i], url)
+			return fetchedStock{stock: stock, validator: validator}, err
+		},
+		func(i int, fetched fetchedStock, err error) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, ErrNotModified) {
+				report.UnchangedTickers = append(report.UnchangedTickers, tickers[i])
+				b.snapshotUnchanged(ctx, tickers[i:i+1])
+				return nil
+			}
+			if errors.Is(err, ErrNoStockInfo) {
+				report.SkippedTickers = append(report.SkippedTickers, ItemError{Item: tickers[i], Err: err})
+				return nil
+			}
+			if err != nil {
+				if !errors.Is(err, ErrCircuitOpen) {
+					log.Printf("Failed to fetch data for ticker %s: %v", tickers[i], err)
+				}
+				report.FailedTickers = append(report.FailedTickers, ItemError{Item: tickers[i], Err: err})
+				return nil
+			}
+
+			if b.saveReported(ctx, fetched.stock, report) {
+				saveValidator(ctx, b.DB, fetched.validator)
+			}
+			return nil
+		},
+	)
+}
+
+// fetchAllInfoBatched fetches and saves data for all given tickers, sending BatchSize tickers per request
+func (b *BasicStockInfoFetcher) fetchAllInfoBatched(ctx context.Context, tickers []string, url string, report *FetchReport) error {
+	chunks := chunkTickers(tickers, b.BatchSize)
+
+	return runOrdered(len(chunks), b.workers(),
+		func(i int) (StockInfoBatch, error) {
+			return b.FetchStockInfoBatch(ctx, chunks[i], url)
+		},
+		func(i int, batch StockInfoBatch, err error) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, ErrNotModified) {
+				report.UnchangedTickers = append(report.UnchangedTickers, chunks[i]...)
+				b.snapshotUnchanged(ctx, chunks[i])
+				return nil
+			}
+			if err != nil {
+				if !errors.Is(err, ErrCircuitOpen) {
+					log.Printf("Failed to fetch data for tickers %s: %v", strings.Join(chunks[i], ","), err)
+				}
+				for _, ticker := range chunks[i] {
+					report.FailedTickers = append(report.FailedTickers, ItemError{Item: ticker, Err: err})
+				}
+				return nil
+			}
+
+			if len(batch.Missing) > 0 {
+				log.Printf("No data returned for tickers %s", strings.Join(batch.Missing, ","))
+			}
+			for _, ticker := range batch.Missing {
+				report.SkippedTickers = append(report.SkippedTickers, ItemError{Item: ticker, Err: ErrNoStockInfo})
+			}
+
+			saved := true
+			for _, stock := range batch.Stocks {
+				saved = b.saveReported(ctx, stock, report) && saved
+			}
+			// the response is only known to be saved if all of its stocks are
+			if saved {
+				saveValidator(ctx, b.DB, batch.Validator)
+			}
+
+			return nil
+		},
+	)
+}
+
+// snapshotUnchanged appends a snapshot of the stored quote of tickers whose quote didn't change, so the price history
+// still has a point for this run. A failure is only logged, as the stored quote is up to date anyway
+func (b *BasicStockInfoFetcher) snapshotUnchanged(ctx context.Context, tickers []string) {
+	var stocks []models.Stock
+	if err := b.DB.WithContext(ctx).Where("ticker IN ?", tickers).Find(&stocks).Error; err != nil {
+		log.Printf("Failed to get the unchanged quotes of %s: %v", strings.Join(tickers, ","), err)
+		return
+	}
+	if len(stocks) == 0 {
+		return
+	}
+
+	fetchedAt := time.Now()
+	snapshots := make([]models.PriceSnapshot, len(stocks))
+	for i, stock := range stocks {
+		snapshots[i] = models.NewPriceSnapshot(stock, fetchedAt)
+	}
+	if err := b.DB.WithContext(ctx).Create(&snapshots).Error; err != nil {
+		log.Printf("Failed to save the price snapshots of %s: %v", strings.Join(tickers, ","), err)
+	}
+}
+
+// saveReported saves the stock and records the outcome in the report. Tells whether the stock was saved
+func (b *BasicStockInfoFetcher) saveReported(ctx context.Context, stock models.Stock, report *FetchReport) bool {
+	if err := b.SaveStockInfo(ctx, stock); err != nil {
+		log.Printf("Failed to save data for ticker %s: %v", stock.Ticker, err)
+		report.FailedTickers = append(report.FailedTickers, ItemError{Item: stock.Ticker, Err: err})
+		return false
+	}
+	report.SucceededTickers = append(report.SucceededTickers, stock.Ticker)
+	return true
+}
 
-		if err := b.SaveStockInfo(stock); err != nil {
-			return fmt.Errorf("failed to save data for ticker %s: %w", ticker, err)
+// workers gets the configured amount of workers, falling back to DefaultInfoWorkers
+func (b *BasicStockInfoFetcher) workers() int {
+	if b.Workers <= 0 {
+		return DefaultInfoWorkers
+	}
+	return b.Workers
+}
+
+// tickerKey matches the tickers of the info API to the requested ones, whichever share class separator they use.
+// Tickers that can't be normalized are only compared in upper case
+func tickerKey(ticker string) string {
+	if canonical, err := models.NormalizeTicker(ticker); err == nil {
+		return canonical
+	}
+	return strings.ToUpper(strings.TrimSpace(ticker))
+}
+
+// uniqueTickers removes repeated tickers, keeping the order of their first appearance
+func uniqueTickers(tickers []string) []string {
+	seen := make(map[string]bool, len(tickers))
+	unique := make([]string, 0, len(tickers))
+	for _, ticker := range tickers {
+		if seen[ticker] {
+			continue
 		}
+		seen[ticker] = true
+		unique = append(unique, ticker)
 	}
+	return unique
+}
 
-	return nil
+// chunkTickers splits the tickers in chunks of at most size elements
+func chunkTickers(tickers []string, size int) [][]string {
+	var chunks [][]string
+	for start := 0; start < len(tickers); start += size {
+		end := min(start+size, len(tickers))
+		chunks = append(chunks, tickers[start:end])
+	}
+	return chunks
 }
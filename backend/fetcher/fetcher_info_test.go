@@ -1,13 +1,18 @@
 package fetcher
 
 import (
+	"context"
+	"fmt"
 	"github.com/c4ts0up/my-stocks/backend/models"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 const mockToken = "mock-token-123"
@@ -16,7 +21,7 @@ const mockToken = "mock-token-123"
 func TestFetchStockInfo_InvalidURL(t *testing.T) {
 	fetcher := BasicStockInfoFetcher{BearerToken: mockToken}
 
-	_, err := fetcher.FetchStockInfo("AAPL", ":://bad-url")
+	_, err := fetcher.FetchStockInfo(context.Background(), "AAPL", ":://bad-url")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "invalid base URL:")
 }
@@ -29,7 +34,7 @@ func TestFetchStockInfo_WrongResponse(t *testing.T) {
 	defer server.Close()
 
 	fetcher := BasicStockInfoFetcher{BearerToken: mockToken}
-	_, err := fetcher.FetchStockInfo("AAPL", server.URL)
+	_, err := fetcher.FetchStockInfo(context.Background(), "AAPL", server.URL)
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "unexpected status code")
@@ -46,7 +51,7 @@ func TestFetchStockInfo_ReadBodyError(t *testing.T) {
 
 	fetcher := BasicStockInfoFetcher{BearerToken: mockToken}
 
-	_, err := fetcher.FetchStockInfo("AAPL", server.URL)
+	_, err := fetcher.FetchStockInfo(context.Background(), "AAPL", server.URL)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to read response body")
 }
@@ -61,7 +66,7 @@ func TestFetchStockInfo_ParseJsonError(t *testing.T) {
 
 	fetcher := BasicStockInfoFetcher{BearerToken: mockToken}
 
-	_, err := fetcher.FetchStockInfo("AAPL", server.URL)
+	_, err := fetcher.FetchStockInfo(context.Background(), "AAPL", server.URL)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to parse JSON")
 }
@@ -83,7 +88,7 @@ func TestFetchStockInfo_OK(t *testing.T) {
 
 	fetcher := BasicStockInfoFetcher{BearerToken: mockToken}
 
-	stock, err := fetcher.FetchStockInfo("AAPL", server.URL+"?ticker")
+	stock, err := fetcher.FetchStockInfo(context.Background(), "AAPL", server.URL+"?ticker")
 	assert.NoError(t, err)
 	assert.Equal(t, "AAPL", stock.Ticker)
 	assert.Equal(t, "Apple Inc.", stock.Company)
@@ -94,12 +99,12 @@ func TestFetchStockInfo_OK(t *testing.T) {
 func TestSaveStockInfo(t *testing.T) {
 	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	assert.NoError(t, err)
-	_ = db.AutoMigrate(&models.Stock{})
+	_ = db.AutoMigrate(&models.Stock{}, &models.PriceSnapshot{})
 
 	fetcher := BasicStockInfoFetcher{DB: db, BearerToken: mockToken}
 
 	stock := models.Stock{Ticker: "AAPL", Company: "Apple Inc."}
-	err = fetcher.SaveStockInfo(stock)
+	err = fetcher.SaveStockInfo(context.Background(), stock)
 	assert.NoError(t, err)
 
 	var count int64
@@ -127,11 +132,11 @@ func TestFetchAllInfo_MultipleTickers(t *testing.T) {
 
 	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	assert.NoError(t, err)
-	_ = db.AutoMigrate(&models.Stock{})
+	_ = db.AutoMigrate(&models.Stock{}, &models.PriceSnapshot{})
 
 	fetcher := BasicStockInfoFetcher{DB: db, BearerToken: mockToken}
 	tickers := []string{"AAPL", "GOOGL"}
-	err = fetcher.FetchAllInfo(tickers, server.URL+"?tickers")
+	_, err = fetcher.FetchAllInfo(context.Background(), tickers, server.URL+"?tickers")
 	assert.NoError(t, err)
 
 	var stocks []models.Stock
@@ -140,3 +145,326 @@ func TestFetchAllInfo_MultipleTickers(t *testing.T) {
 	assert.Equal(t, "AAPL", stocks[0].Ticker)
 	assert.Equal(t, "GOOGL", stocks[1].Ticker)
 }
+
+// --- TEST CASE 8: FetchAllInfo respects the worker cap ---
+func TestFetchAllInfo_WorkerCap(t *testing.T) {
+	var inFlight, maxInFlight int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			observed := atomic.LoadInt32(&maxInFlight)
+			if current <= observed || atomic.CompareAndSwapInt32(&maxInFlight, observed, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		ticker := r.URL.Query().Get("tickers")
+		_, _ = w.Write([]byte(fmt.Sprintf(`[{"ticker":"%s","companyName":"%s Inc."}]`, ticker, ticker)))
+	}))
+	defer server.Close()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	_ = db.AutoMigrate(&models.Stock{}, &models.PriceSnapshot{})
+
+	tickers := []string{"A", "B", "C", "D", "E", "F", "G", "H"}
+	fetcher := BasicStockInfoFetcher{DB: db, BearerToken: mockToken, Workers: 3}
+	_, err = fetcher.FetchAllInfo(context.Background(), tickers, server.URL)
+	assert.NoError(t, err)
+
+	assert.LessOrEqual(t, atomic.LoadInt32(&maxInFlight), int32(3))
+	assert.Greater(t, atomic.LoadInt32(&maxInFlight), int32(1))
+
+	var stocks []models.Stock
+	db.Find(&stocks)
+	assert.Len(t, stocks, len(tickers))
+	for i, stock := range stocks {
+		assert.Equal(t, tickers[i], stock.Ticker)
+	}
+}
+
+// --- TEST CASE 9: FetchAllInfo keeps going past a failing ticker ---
+func TestFetchAllInfo_ContinuesAfterFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ticker := r.URL.Query().Get("tickers")
+		if ticker == "BAD" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(fmt.Sprintf(`[{"ticker":"%s"}]`, ticker)))
+	}))
+	defer server.Close()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	_ = db.AutoMigrate(&models.Stock{}, &models.PriceSnapshot{})
+
+	fetcher := BasicStockInfoFetcher{DB: db, BearerToken: mockToken, Workers: 4}
+	report, err := fetcher.FetchAllInfo(context.Background(), []string{"AAPL", "MSFT", "BAD", "GOOGL", "TSLA"}, server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AAPL", "MSFT", "GOOGL", "TSLA"}, report.SucceededTickers)
+	assert.Len(t, report.FailedTickers, 1)
+	assert.Equal(t, "BAD", report.FailedTickers[0].Item)
+	assert.Contains(t, report.FailedTickers[0].Error(), "unexpected status code")
+
+	var stocks []models.Stock
+	db.Find(&stocks)
+	assert.Len(t, stocks, 4)
+	assert.Equal(t, "AAPL", stocks[0].Ticker)
+	assert.Equal(t, "TSLA", stocks[3].Ticker)
+}
+
+// --- TEST CASE 10: FetchStockInfoBatch matches rows and reports missing tickers ---
+func TestFetchStockInfoBatch_MissingTickers(t *testing.T) {
+	var requested string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Query().Get("tickers")
+		_, _ = w.Write([]byte(`[
+			{"ticker":"MSFT","lastPrice":410.5,"companyName":"Microsoft Corporation"},
+			{"ticker":"AAPL","lastPrice":214.65,"companyName":"Apple Inc."},
+			{"ticker":"NVDA","lastPrice":120.1,"companyName":"NVIDIA Corporation"}
+		]`))
+	}))
+	defer server.Close()
+
+	fetcher := BasicStockInfoFetcher{BearerToken: mockToken}
+
+	batch, err := fetcher.FetchStockInfoBatch(context.Background(), []string{"AAPL", "DLST", "MSFT"}, server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "AAPL,DLST,MSFT", requested)
+	assert.Len(t, batch.Stocks, 2)
+	assert.Equal(t, "AAPL", batch.Stocks[0].Ticker)
+	assert.Equal(t, 214.65, batch.Stocks[0].LastPrice)
+	assert.Equal(t, "MSFT", batch.Stocks[1].Ticker)
+	assert.Equal(t, []string{"DLST"}, batch.Missing)
+}
+
+// --- TEST CASE 11: FetchAllInfo in batched mode ---
+func TestFetchAllInfo_Batched(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		var rows []string
+		for _, ticker := range strings.Split(r.URL.Query().Get("tickers"), ",") {
+			if ticker == "DLST" {
+				continue
+			}
+			rows = append(rows, fmt.Sprintf(`{"ticker":"%s","companyName":"%s Inc."}`, ticker, ticker))
+		}
+		_, _ = w.Write([]byte("[" + strings.Join(rows, ",") + "]"))
+	}))
+	defer server.Close()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	_ = db.AutoMigrate(&models.Stock{}, &models.PriceSnapshot{})
+
+	fetcher := BasicStockInfoFetcher{DB: db, BearerToken: mockToken, BatchSize: 2}
+	report, err := fetcher.FetchAllInfo(context.Background(), []string{"AAPL", "GOOGL", "AAPL", "DLST", "MSFT"}, server.URL)
+	assert.NoError(t, err)
+	assert.Len(t, report.SkippedTickers, 1)
+	assert.Equal(t, "DLST", report.SkippedTickers[0].Item)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	var stocks []models.Stock
+	db.Find(&stocks)
+	assert.Len(t, stocks, 3)
+	assert.Equal(t, "AAPL", stocks[0].Ticker)
+	assert.Equal(t, "GOOGL", stocks[1].Ticker)
+	assert.Equal(t, "MSFT", stocks[2].Ticker)
+}
+
+// --- TEST CASE 12: FetchStockInfo honors the request timeout ---
+func TestFetchStockInfo_RequestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	fetcher := BasicStockInfoFetcher{BearerToken: mockToken, RequestTimeout: 20 * time.Millisecond}
+
+	start := time.Now()
+	_, err := fetcher.FetchStockInfo(context.Background(), "AAPL", server.URL)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+// --- TEST CASE 13: FetchAllInfo stops when the run is cancelled ---
+func TestFetchAllInfo_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ticker := r.URL.Query().Get("tickers")
+		if ticker == "GOOGL" {
+			cancel()
+		}
+		_, _ = w.Write([]byte(fmt.Sprintf(`[{"ticker":"%s"}]`, ticker)))
+	}))
+	defer server.Close()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	_ = db.AutoMigrate(&models.Stock{}, &models.PriceSnapshot{})
+
+	fetcher := BasicStockInfoFetcher{DB: db, BearerToken: mockToken, Workers: 1}
+	_, err = fetcher.FetchAllInfo(ctx, []string{"AAPL", "MSFT", "GOOGL", "TSLA"}, server.URL)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// tickers after the cancellation are never saved
+	var count int64
+	db.Model(&models.Stock{}).Count(&count)
+	assert.LessOrEqual(t, count, int64(2))
+}
+
+// --- TEST CASE 14: Conditional requests skip unchanged quotes ---
+func TestFetchAllInfo_ConditionalRequests(t *testing.T) {
+	var parsed int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ticker := r.URL.Query().Get("tickers")
+		etag := `"` + ticker + `-v1"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(&parsed, 1)
+		w.Header().Set("ETag", etag)
+		var rows []string
+		for _, requested := range strings.Split(ticker, ",") {
+			rows = append(rows, fmt.Sprintf(`{"ticker":"%s","price":"1.00"}`, requested))
+		}
+		_, _ = w.Write([]byte("[" + strings.Join(rows, ",") + "]"))
+	}))
+	defer server.Close()
+
+	db := models.NewTestDB(nil)
+	fetcher := BasicStockInfoFetcher{DB: db, Workers: 2, ConditionalRequests: true}
+
+	report, err := fetcher.FetchAllInfo(context.Background(), []string{"AAPL", "MSFT"}, server.URL)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"AAPL", "MSFT"}, report.SucceededTickers)
+
+	// the saved stocks are left alone when the quotes didn't change
+	db.Model(&models.Stock{}).Where("ticker = ?", "AAPL").Update("last_price", 99)
+	report, err = fetcher.FetchAllInfo(context.Background(), []string{"AAPL", "MSFT"}, server.URL)
+	assert.NoError(t, err)
+	assert.Empty(t, report.SucceededTickers)
+	assert.Equal(t, []string{"AAPL", "MSFT"}, report.UnchangedTickers)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&parsed))
+
+	var stock models.Stock
+	db.Where("ticker = ?", "AAPL").First(&stock)
+	assert.Equal(t, 99.0, stock.LastPrice)
+
+	// the unchanged quotes still get a snapshot, of the stored quote
+	snapshots, err := models.GetPriceSnapshots(context.Background(), db, "AAPL", time.Time{})
+	assert.NoError(t, err)
+	assert.Len(t, snapshots, 2)
+	assert.Equal(t, 99.0, snapshots[1].LastPrice)
+	assert.True(t, snapshots[1].FetchedAt.After(snapshots[0].FetchedAt))
+
+	// and so do those of a batch
+	fetcher.BatchSize = 2
+	_, err = fetcher.FetchAllInfo(context.Background(), []string{"AAPL", "MSFT"}, server.URL)
+	assert.NoError(t, err)
+	report, err = fetcher.FetchAllInfo(context.Background(), []string{"AAPL", "MSFT"}, server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AAPL", "MSFT"}, report.UnchangedTickers)
+	snapshots, err = models.GetPriceSnapshots(context.Background(), db, "MSFT", time.Time{})
+	assert.NoError(t, err)
+	assert.Len(t, snapshots, 4)
+}
+
+// --- TEST CASE 15: Every saved quote appends a price snapshot ---
+func TestSaveStockInfo_PriceSnapshots(t *testing.T) {
+	db := models.NewTestDB(nil)
+	fetcher := BasicStockInfoFetcher{DB: db}
+
+	err := fetcher.SaveStockInfo(context.Background(), models.Stock{Ticker: "AAPL", Open: 211.51, LastClose: 214.1, LastPrice: 214.65, Percentage: 0.26, Currency: "USD"})
+	assert.NoError(t, err)
+	err = fetcher.SaveStockInfo(context.Background(), models.Stock{Ticker: "AAPL", Open: 214.1, LastClose: 214.65, LastPrice: 214.65, Currency: "USD"})
+	assert.NoError(t, err)
+
+	snapshots, err := models.GetPriceSnapshots(context.Background(), db, "AAPL", time.Time{})
+	assert.NoError(t, err)
+	assert.Len(t, snapshots, 2)
+	assert.Equal(t, 0.26, snapshots[0].Percentage)
+	assert.Equal(t, 214.1, snapshots[1].Open)
+
+	// the stock keeps the latest quote, a flat one included
+	var stock models.Stock
+	db.Where("ticker = ?", "AAPL").First(&stock)
+	assert.Equal(t, 214.1, stock.Open)
+	assert.Equal(t, 0.0, stock.Percentage)
+}
+
+// --- TEST CASE 16: Tickers are sent in the convention of the info API and saved in the canonical one ---
+func TestFetchAllInfo_TickerSeparator(t *testing.T) {
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Query().Get("tickers"))
+		_, _ = w.Write([]byte(`[
+			{"ticker":"brk-b","lastPrice":480.1,"companyName":"Berkshire Hathaway Inc."},
+			{"ticker":"AAPL","lastPrice":214.65,"companyName":"Apple Inc."}
+		]`))
+	}))
+	defer server.Close()
+
+	db := models.NewTestDB(nil)
+	fetcher := BasicStockInfoFetcher{DB: db, BatchSize: 5, TickerSeparator: "-"}
+
+	report, err := fetcher.FetchAllInfo(context.Background(), []string{"brk.b", "AAPL", "NULL"}, server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"BRK-B,AAPL"}, requested)
+	assert.Equal(t, []string{"BRK.B", "AAPL"}, report.SucceededTickers)
+	assert.Len(t, report.SkippedTickers, 1)
+	assert.ErrorIs(t, report.SkippedTickers[0].Err, models.ErrInvalidTicker)
+
+	var stock models.Stock
+	assert.NoError(t, db.Where("ticker = ?", "BRK.B").First(&stock).Error)
+	assert.Equal(t, 480.1, stock.LastPrice)
+}
+
+// --- TEST CASE 17: A ticker rated several times is fetched once and gets a single price snapshot ---
+func TestFetchAll_DuplicateTickers(t *testing.T) {
+	ratingsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"items": [
+			{"ticker": "AAPL", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "A", "time": "2025-01-13T00:30:05Z"},
+			{"ticker": "AAPL", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "B", "time": "2025-01-13T00:30:05Z"},
+			{"ticker": "MSFT", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "A", "time": "2025-01-13T00:30:05Z"}
+		], "next_page": ""}`))
+	}))
+	defer ratingsServer.Close()
+	var requests int32
+	infoServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		_, _ = w.Write([]byte(fmt.Sprintf(`[{"ticker":"%s","price":"1.00"}]`, r.URL.Query().Get("tickers"))))
+	}))
+	defer infoServer.Close()
+
+	db := models.NewTestDB(nil)
+	stockFetcher := StockFetcher{
+		RatingsFetcher: &BasicStockRatingsFetcher{DB: db, BearerToken: mockToken},
+		InfoFetcher:    &BasicStockInfoFetcher{DB: db},
+	}
+	report, err := stockFetcher.FetchAll(context.Background(), ratingsServer.URL, infoServer.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AAPL", "MSFT"}, report.SucceededTickers)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	// tickers given twice to the info fetcher are fetched once too
+	_, err = stockFetcher.InfoFetcher.FetchAllInfo(context.Background(), []string{"AAPL", "aapl", "AAPL"}, infoServer.URL)
+	assert.NoError(t, err)
+
+	for ticker, expected := range map[string]int{"AAPL": 2, "MSFT": 1} {
+		snapshots, err := models.GetPriceSnapshots(context.Background(), db, ticker, time.Time{})
+		assert.NoError(t, err)
+		assert.Len(t, snapshots, expected, ticker)
+	}
+}
@@ -1,99 +1,315 @@
 package fetcher
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/c4ts0up/my-stocks/backend/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"io"
 	"log"
 	"net/http"
+	"time"
 )
 
 type BasicStockRatingsFetcher struct {
-	DB          *gorm.DB
+	DB *gorm.DB
+	// Auth authenticates the requests to the ratings API. Defaults to sending BearerToken as a bearer token
+	Auth        IAuthenticator
 	BearerToken string
+	Retry       RetryPolicy
+	// RequestTimeout bounds each request to the ratings API, including reading its body. Zero means no timeout
+	RequestTimeout time.Duration
+	// Limiter throttles the requests to the ratings API. It is usually shared with the other fetchers. Nil means no limit
+	Limiter *RateLimiter
+	// Breaker skips the requests to the ratings API while it is down. Nil means requests are always sent
+	Breaker *CircuitBreaker
+	// ConditionalRequests makes FetchStockRatings send the validators of the last saved response of each URL, so
+	// unchanged pages are neither downloaded nor saved again
+	ConditionalRequests bool
+	// Incremental makes FetchAllRatings resume from the persisted sync state instead of re-downloading the whole feed
+	Incremental bool
+	// Sources are the ratings sources synced by FetchAllRatings. When empty, the ratings API is the only source
+	Sources []RatingsSource
+	// Fixtures records the traffic of the ratings API, or replays it instead of calling the API. Nil means neither
+	Fixtures *Fixtures
 }
 
-// FetchStockRatings pulls stock ratings from the given API and converts them to StockRating models
-func (s *BasicStockRatingsFetcher) FetchStockRatings(url string) ([]models.StockRating, string, error) {
+// StockRatingsPage holds a page of stock ratings, the malformed rows left out of it and the cursor of the next page
+type StockRatingsPage struct {
+	Ratings  []models.StockRating
+	Skipped  []ItemError
+	NextPage string
+	// Quarantined holds the skipped rows as they were received, to be kept until they can be replayed
+	Quarantined []models.QuarantinedRating
+	// NotModified tells the page didn't change since it was last saved, so it holds no ratings
+	NotModified bool
+	// Validator holds the validators of the page, to be saved along with its ratings. Nil if there are none
+	Validator *models.ResponseValidator
+}
+
+// FetchStockRatings pulls stock ratings from the given API and converts them to StockRating models. Rows that
+// can't be converted are left out of the page and listed as skipped. With ConditionalRequests, a page that didn't
+// change since it was last saved is neither read nor parsed and comes back as NotModified
+func (s *BasicStockRatingsFetcher) FetchStockRatings(ctx context.Context, url string) (StockRatingsPage, error) {
+	var ratings []models.StockRating
+	page, err := s.StreamStockRatings(ctx, url, func(batch []models.StockRating) error {
+		ratings = append(ratings, batch...)
+		return nil
+	})
+	if err != nil {
+		return StockRatingsPage{}, err
+	}
+
+	page.Ratings = ratings
+	return page, nil
+}
+
+// StreamStockRatings pulls stock ratings from the given API like FetchStockRatings, but decodes the items one at a
+// time and hands them over to consume in batches as they are read, so memory doesn't grow with the size of the page.
+// An error returned by consume stops the stream and is returned as is. The batch is reused afterwards, so consume
+// must not keep it. The returned page holds no ratings
+func (s *BasicStockRatingsFetcher) StreamStockRatings(ctx context.Context, url string, consume func(batch []models.StockRating) error) (StockRatingsPage, error) {
 	log.Printf("Fetching stock data from %s\n", url)
 
 	// Create a new HTTP request
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to create request: %w", err)
+		return StockRatingsPage{}, fmt.Errorf("failed to create request: %w", err)
 	}
-	if s.BearerToken == "" {
-		return nil, "", fmt.Errorf("no bearer token provided")
+	auth := s.Auth
+	if auth == nil {
+		auth = &BearerAuth{Tokens: NewTokenRotation([]string{s.BearerToken})}
 	}
 
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.BearerToken))
+	var validator models.ResponseValidator
+	if s.ConditionalRequests {
+		validator = loadValidator(ctx, s.DB, url)
+		setConditionalHeaders(req, validator)
+	}
 
 	// Execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	client := newHttpClient(s.RequestTimeout, s.Breaker, s.Limiter, s.Fixtures)
+	resp, err := s.Retry.Do(client, req, auth)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to fetch data from %v: %w", url, err)
+		return StockRatingsPage{}, fmt.Errorf("failed to fetch data from %v: %w", url, err)
 	}
 	defer func(Body io.ReadCloser) {
 		_ = Body.Close()
 	}(resp.Body)
 
+	if resp.StatusCode == http.StatusNotModified && s.ConditionalRequests {
+		log.Printf("Stock data from %s not modified", url)
+		return StockRatingsPage{NextPage: validator.NextPage, NotModified: true}, nil
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("received invalid response from API: %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+		return StockRatingsPage{}, fmt.Errorf("received invalid response from API: %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, "", errors.New("failed to read response body")
-	}
+	// converts the stocks as they are read, handing them over in batches
+	var page StockRatingsPage
+	batch := make([]models.StockRating, 0, ratingsStreamBatchSize)
+	var consumeErr error
+	row := 0
+	body := &readErrorReader{r: resp.Body}
 
-	// obtains the stock query response
-	var apiResponses models.StockQueryResponse
-	if err := json.Unmarshal(body, &apiResponses); err != nil {
-		return nil, "", errors.New("failed to parse JSON response")
-	}
+	nextPage, err := decodeStockQueryStream(body, func(rawJson json.RawMessage) error {
+		defer func() { row++ }()
 
-	// iterates through the stocks
-	var stockRatings []models.StockRating
-	for _, rawStock := range apiResponses.Stocks {
-		stock, err := convertStockRatingsApiResponse(rawStock)
+		var rawStock models.StockRatingRaw
+		err := json.Unmarshal(rawJson, &rawStock)
+		if err == nil {
+			var stock models.StockRating
+			if stock, err = convertStockRatingsApiResponse(rawStock); err == nil {
+				batch = append(batch, stock)
+			}
+		}
 		if err != nil {
-			return nil, "", fmt.Errorf("failed to parse stock data, got %v", err)
+			page.reject(url, row, rawStock.Ticker, models.RatingFormatApi, rawJson, err)
+			return nil
+		}
+
+		if len(batch) == ratingsStreamBatchSize {
+			consumeErr = consume(batch)
+			batch = batch[:0]
+		}
+		return consumeErr
+	})
+	switch {
+	case consumeErr != nil:
+		return StockRatingsPage{}, consumeErr
+	case body.err != nil:
+		return StockRatingsPage{}, errors.New("failed to read response body")
+	case err != nil:
+		return StockRatingsPage{}, errors.New("failed to parse JSON response")
+	}
+
+	if len(batch) > 0 {
+		if err := consume(batch); err != nil {
+			return StockRatingsPage{}, err
 		}
-		stockRatings = append(stockRatings, stock)
+	}
+
+	page.NextPage = nextPage
+	if s.ConditionalRequests {
+		page.Validator = responseValidator(url, resp, page.NextPage)
 	}
 
 	err = resp.Body.Close()
 	if err != nil {
-		return nil, "", err
+		return StockRatingsPage{}, err
 	}
 
-	return stockRatings, apiResponses.NextPage, nil
+	return page, nil
 }
 
-// SaveStockRatings saves StockRating models to the database (stub implementation). Supposes there are no conflicts in the API and Stocks are already created
-func (s *BasicStockRatingsFetcher) SaveStockRatings(stockList []models.StockRating) error {
+// SaveStockRatings appends the StockRating models to the rating history and refreshes the current rating of each
+// broker from it. Revisions are deduplicated by their revision key, so saving the same page twice changes nothing.
+// The whole list is written in a single transaction. Supposes Stocks are already created
+func (s *BasicStockRatingsFetcher) SaveStockRatings(ctx context.Context, stockList []models.StockRating) error {
 	log.Printf("Saving stock data to database")
 
-	// Upsert each stock record (insert or update)
-	for _, stock := range stockList {
-		err := s.DB.Where("ticker = ? AND time = ?", stock.Ticker, stock.Time).
-			Assign(stock).
-			FirstOrCreate(&stock).Error
+	_, err := s.saveStockRatings(ctx, stockList)
+	return err
+}
+
+// saveStockRatings saves the ratings like SaveStockRatings, and counts the ones new to the rating history
+func (s *BasicStockRatingsFetcher) saveStockRatings(ctx context.Context, stockList []models.StockRating) (int, error) {
+	var added int
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		writer := newRatingsWriter(tx)
+		if err := writer.write(stockList); err != nil {
+			return err
+		}
+		added = writer.added
+		return writer.finish()
+	})
+	return added, err
+}
+
+// ratingsWriter appends ratings to the rating history within a transaction, possibly in several batches, and
+// refreshes the current ratings of the brokers it touched once every batch is written
+type ratingsWriter struct {
+	tx      *gorm.DB
+	touched []brokerRating
+	seen    map[brokerRating]bool
+	// brokerages caches the canonical name of each brokerage name written
+	brokerages map[string]string
+	// stocks remembers the stocks known to exist, and whether they are known to have a company
+	stocks map[string]bool
+	// added counts the written ratings that were new to the rating history
+	added int
+}
+
+type brokerRating struct {
+	ticker    string
+	brokerage string
+}
+
+func newRatingsWriter(tx *gorm.DB) *ratingsWriter {
+	return &ratingsWriter{tx: tx, seen: make(map[brokerRating]bool), brokerages: make(map[string]string),
+		stocks: make(map[string]bool)}
+}
 
+// write appends a batch of ratings to the rating history
+func (w *ratingsWriter) write(stockList []models.StockRating) error {
+	for _, stock := range stockList {
+		isNew, err := w.writeRating(stock)
 		if err != nil {
 			return err
 		}
+		if isNew {
+			w.added++
+		}
+	}
+	return nil
+}
+
+// writeRating appends a rating to the rating history. Tells whether it is new, as a revision already in the
+// history is left alone
+func (w *ratingsWriter) writeRating(stock models.StockRating) (bool, error) {
+	// the revision is keyed by the brokerage as the feed gave it, so merging brokerages doesn't change the keys
+	if stock.RevisionKey == "" {
+		stock.RevisionKey = models.StockRatingContentKey(stock)
+	}
+	brokerage, err := w.canonicalBrokerage(stock.Brokerage)
+	if err != nil {
+		return false, err
+	}
+	stock.Brokerage = brokerage
+	if stock.ActionType == "" {
+		stock.ActionType = classifyRatingAction(stock)
+	}
+	if err := w.ensureStock(stock.Ticker, stock.Company); err != nil {
+		return false, err
+	}
+
+	revision := models.NewStockRatingRevision(stock)
+	result := w.tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "revision_key"}},
+		DoNothing: true,
+	}).Create(&revision)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	key := brokerRating{ticker: stock.Ticker, brokerage: stock.Brokerage}
+	if !w.seen[key] {
+		w.seen[key] = true
+		w.touched = append(w.touched, key)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// canonicalBrokerage gets the canonical name of a brokerage, remembering it for the rest of the writes
+func (w *ratingsWriter) canonicalBrokerage(name string) (string, error) {
+	if canonical, ok := w.brokerages[name]; ok {
+		return canonical, nil
+	}
+	brokerage, err := models.ResolveBrokerage(w.tx, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve the brokerage %q: %w", name, err)
+	}
+	w.brokerages[name] = brokerage.Name
+	return brokerage.Name, nil
+}
+
+// ensureStock creates a placeholder stock for the rated ticker when it has none yet, remembering it for the rest of
+// the writes
+func (w *ratingsWriter) ensureStock(ticker string, company string) error {
+	if named, ok := w.stocks[ticker]; ok && (named || company == "") {
+		return nil
 	}
+	if err := models.EnsureStock(w.tx, ticker, company); err != nil {
+		return fmt.Errorf("failed to create the stock %s: %w", ticker, err)
+	}
+	w.stocks[ticker] = company != ""
+	return nil
+}
 
+// finish refreshes the current rating of every broker touched by the written ratings
+func (w *ratingsWriter) finish() error {
+	for _, key := range w.touched {
+		if err := models.RefreshCurrentRating(w.tx, key.ticker, key.brokerage); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// commit finishes the writer and commits its transaction, rolling it back if anything fails
+func (w *ratingsWriter) commit() error {
+	if err := w.finish(); err != nil {
+		w.tx.Rollback()
+		return err
+	}
+	return w.tx.Commit().Error
+}
+
 // GetStockTickers gets the tickers from the StockRating list obtained after fetching
 func (s *BasicStockRatingsFetcher) GetStockTickers(stockRatings []models.StockRating) []string {
 	log.Printf("Extracting stock tickers")
@@ -106,36 +322,246 @@ func (s *BasicStockRatingsFetcher) GetStockTickers(stockRatings []models.StockRa
 	return tickers
 }
 
-// FetchAllRatings fetches all the pages in the database and saves them in the ORM
-func (s *BasicStockRatingsFetcher) FetchAllRatings(url string) ([]string, error) {
-	nextPage := ""
+// FetchAllRatings fetches the pages of every ratings source and saves them in the ORM. When no Sources are
+// configured, the ratings API at the given url is the only source. A page that can't be saved or malformed rows
+// don't stop the run, they are recorded in the report. A page that can't be fetched ends the pagination of its
+// source, so its error is returned along with the tickers of the pages fetched so far.
+// When Incremental is set, each source resumes from its persisted cursor, and stops at a later page already seen
+// before its high-water mark
+func (s *BasicStockRatingsFetcher) FetchAllRatings(ctx context.Context, url string) ([]string, *FetchReport, error) {
+	return s.fetchAllRatings(ctx, url, !s.Incremental)
+}
+
+// Resync re-downloads the whole ratings feed of every source, ignoring the persisted sync state and the validators
+// of the saved responses, and then updates them
+func (s *BasicStockRatingsFetcher) Resync(ctx context.Context, url string) ([]string, *FetchReport, error) {
+	if s.ConditionalRequests {
+		if err := models.ClearResponseValidators(ctx, s.DB); err != nil {
+			return nil, &FetchReport{}, fmt.Errorf("failed to clear the response validators: %w", err)
+		}
+	}
+	return s.fetchAllRatings(ctx, url, true)
+}
+
+// fetchAllRatings syncs every source one after the other
+func (s *BasicStockRatingsFetcher) fetchAllRatings(ctx context.Context, url string, full bool) ([]string, *FetchReport, error) {
+	sources := s.Sources
+	if len(sources) == 0 {
+		sources = []RatingsSource{{Name: url, Source: &apiRatingsSource{fetcher: s, url: url}}}
+	}
+
 	var tickers []string
+	var errs []error
+	report := &FetchReport{}
+	for _, source := range sources {
+		sourceTickers, err := s.syncSource(ctx, source, full, report)
+		tickers = append(tickers, sourceTickers...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ratings source %s: %w", source.Name, err))
+		}
+	}
+
+	// unchanged pages give no tickers, so they must be taken from the database as on an incremental sync
+	return s.syncedTickers(ctx, tickers, full && len(report.UnchangedPages) == 0), report, errors.Join(errs...)
+}
+
+// syncSource walks the pages of a source, starting from the first page on a full sync or from the persisted cursor
+// otherwise. The page resumed from is fetched again, in case the feed grew, and the walk goes on from it until the
+// last page, or until a page that is unchanged or holds no rating newer than the high-water mark nor any new one.
+// Ratings are deduplicated by their revision key rather than by their time, so old ratings showing up late on the
+// walked pages are still saved. The sync state is checkpointed after each saved page, up to the first page that fails
+func (s *BasicStockRatingsFetcher) syncSource(ctx context.Context, source RatingsSource, full bool, report *FetchReport) ([]string, error) {
+	var tickers []string
+
+	state, err := models.GetRatingsSyncState(ctx, s.DB, source.Name)
+	if err != nil {
+		return tickers, fmt.Errorf("failed to load the sync state: %w", err)
+	}
+	highWaterMark := state.HighWaterMark
+
+	nextPage := ""
+	if !full {
+		nextPage = state.Cursor
+		log.Printf("Resuming ratings sync of %s from page %q (newest rating at %v)", source.Name, nextPage, highWaterMark)
+	}
+	resumedFrom := nextPage
+	checkpoint := true
 
 	for {
+		pageName := source.pageName(nextPage)
 
-		// pulls
-		stockRatings, newSuffix, err := s.FetchStockRatings(url + "?next_page=" + nextPage)
+		// pulls and saves
+		synced, err := s.syncPage(ctx, source, nextPage)
 		if err != nil {
-			log.Printf("Entered an error %e", err)
-			return []string{}, err
+			log.Printf("Entered an error %v", err)
+			report.FailedPages = append(report.FailedPages, ItemError{Item: pageName, Err: err})
+			return tickers, err
 		}
+		page := synced.page
+		report.SkippedRatings = append(report.SkippedRatings, page.Skipped...)
+		s.quarantine(ctx, source.Name, page.Quarantined)
 
-		// saves
-		err = s.SaveStockRatings(stockRatings)
-		if err != nil {
-			log.Printf("Entered an error %e", err)
-			return []string{}, err
+		switch {
+		case page.NotModified:
+			report.UnchangedPages = append(report.UnchangedPages, pageName)
+		case synced.saveErr != nil:
+			log.Printf("Entered an error %v", synced.saveErr)
+			report.FailedPages = append(report.FailedPages, ItemError{Item: pageName, Err: synced.saveErr})
+			// later pages are still processed, but the cursor must not move past this one
+			checkpoint = false
+		default:
+			report.SucceededPages = append(report.SucceededPages, pageName)
+			report.SavedRatings += synced.saved
+			saveValidator(ctx, s.DB, page.Validator)
+
+			// adds tickers to return
+			tickers = append(tickers, synced.tickers...)
+		}
+
+		if checkpoint {
+			if synced.latest.After(state.HighWaterMark) {
+				state.HighWaterMark = synced.latest
+			}
+			// the last page is fetched again on the next run, in case the feed grew
+			state.Cursor = nextPage
+			if page.NextPage != "" {
+				state.Cursor = page.NextPage
+			}
+			if err := models.SaveRatingsSyncState(ctx, s.DB, state); err != nil {
+				log.Printf("Failed to save the sync state: %v", err)
+			}
 		}
 
-		// adds tickers to return
-		tickers = append(tickers, s.GetStockTickers(stockRatings)...)
+		// the rest of the feed was seen by a previous run. The page resumed from was seen too, but the feed may have
+		// grown past it
+		if !full && nextPage != resumedFrom && synced.seenBefore(highWaterMark) {
+			log.Printf("Stopping the ratings sync of %s at page %q, which was already seen", source.Name, nextPage)
+			break
+		}
 
 		// checks if there are more pages to fetch
-		if newSuffix == "" {
+		if page.NextPage == "" {
 			break
 		}
-		nextPage = newSuffix
+		nextPage = page.NextPage
 	}
 
 	return tickers, nil
 }
+
+// quarantine keeps the malformed rows of a source page until they are replayed. A failure is only logged, as the
+// rows are listed in the report anyway
+func (s *BasicStockRatingsFetcher) quarantine(ctx context.Context, source string, rows []models.QuarantinedRating) {
+	fetchedAt := time.Now()
+	for i := range rows {
+		rows[i].Source = source
+		rows[i].FetchedAt = fetchedAt
+	}
+	if err := models.SaveQuarantinedRatings(ctx, s.DB, rows); err != nil {
+		log.Printf("Failed to quarantine %d rows of %s: %v", len(rows), source, err)
+	}
+}
+
+// pageSync is the outcome of syncing a page of a ratings source
+type pageSync struct {
+	page StockRatingsPage
+	// tickers, latest and saved describe the ratings saved from the page
+	tickers []string
+	latest  time.Time
+	saved   int
+	// added counts the saved ratings that were new to the rating history
+	added int
+	// saveErr is set when the page was fetched but couldn't be saved
+	saveErr error
+}
+
+// seenBefore tells whether the page was already seen, because it is unchanged or because none of its ratings are new
+// nor newer than the given high-water mark
+func (p pageSync) seenBefore(highWaterMark time.Time) bool {
+	if p.page.NotModified {
+		return true
+	}
+	return p.saveErr == nil && p.saved > 0 && p.added == 0 && !p.latest.After(highWaterMark)
+}
+
+// syncPage fetches a page and saves its ratings, keyed by the name of the source. Streaming sources are saved while the
+// page is being read, within a single transaction, so a failure to save them ends the stream and is returned as an
+// error, like a failure to fetch the page. A failure to commit them is recorded as a save error
+func (s *BasicStockRatingsFetcher) syncPage(ctx context.Context, named RatingsSource, cursor string) (pageSync, error) {
+	source := named.Source
+	var synced pageSync
+	keep := func(ratings []models.StockRating) []models.StockRating {
+		keyBySource(named.Name, ratings)
+		synced.tickers = append(synced.tickers, s.GetStockTickers(ratings)...)
+		synced.latest = latestRatingTime(ratings, synced.latest)
+		synced.saved += len(ratings)
+		return ratings
+	}
+
+	streaming, ok := source.(IStreamingRatingsSource)
+	if !ok {
+		page, err := source.FetchRatingsPage(ctx, cursor)
+		if err != nil {
+			return pageSync{}, err
+		}
+		synced.page = page
+		if page.NotModified {
+			return synced, nil
+		}
+
+		synced.added, synced.saveErr = s.saveStockRatings(ctx, keep(page.Ratings))
+		return synced, nil
+	}
+
+	// the transaction begins with the first batch, once the request is done
+	var writer *ratingsWriter
+	page, err := streaming.StreamRatingsPage(ctx, cursor, func(batch []models.StockRating) error {
+		if writer == nil {
+			tx := s.DB.WithContext(ctx).Begin()
+			if tx.Error != nil {
+				return tx.Error
+			}
+			writer = newRatingsWriter(tx)
+		}
+		return writer.write(keep(batch))
+	})
+	if err != nil {
+		if writer != nil {
+			writer.tx.Rollback()
+		}
+		return pageSync{}, err
+	}
+
+	synced.page = page
+	if writer != nil {
+		synced.added = writer.added
+		synced.saveErr = writer.commit()
+	}
+	return synced, nil
+}
+
+// syncedTickers gets the tickers whose info should be refreshed after a sync, once each. A complete sync, which saved
+// every page, already went through every rated ticker. Otherwise only some pages were seen, so every rated ticker is
+// taken from the database
+func (s *BasicStockRatingsFetcher) syncedTickers(ctx context.Context, fetched []string, complete bool) []string {
+	if complete {
+		return uniqueTickers(fetched)
+	}
+
+	var tickers []string
+	if err := s.DB.WithContext(ctx).Model(&models.StockRating{}).Distinct().Order("ticker").Pluck("ticker", &tickers).Error; err != nil {
+		log.Printf("Failed to get the rated tickers, refreshing only the fetched ones: %v", err)
+		return fetched
+	}
+	return tickers
+}
+
+// latestRatingTime gets the newest time among the ratings and the given time
+func latestRatingTime(ratings []models.StockRating, latest time.Time) time.Time {
+	for _, rating := range ratings {
+		if rating.Time.After(latest) {
+			latest = rating.Time
+		}
+	}
+	return latest
+}
@@ -1,6 +1,8 @@
 package fetcher
 
 import (
+	"context"
+	"fmt"
 	"github.com/c4ts0up/my-stocks/backend/models"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/sqlite"
@@ -8,6 +10,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 const mockTocken = "mock-token-123"
@@ -16,7 +19,7 @@ const mockTocken = "mock-token-123"
 func TestFetchStockData_InvalidURL(t *testing.T) {
 	fetcher := BasicStockRatingsFetcher{BearerToken: mockTocken}
 
-	_, _, err := fetcher.FetchStockRatings(":://bad-url")
+	_, err := fetcher.FetchStockRatings(context.Background(), ":://bad-url")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to create request")
 }
@@ -30,7 +33,7 @@ func TestFetchStockData_WrongResponse(t *testing.T) {
 	defer server.Close()
 
 	fetcher := BasicStockRatingsFetcher{BearerToken: mockTocken}
-	_, _, err := fetcher.FetchStockRatings(server.URL)
+	_, err := fetcher.FetchStockRatings(context.Background(), server.URL)
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "received invalid response from API")
@@ -48,7 +51,7 @@ func TestFetchStockData_ReadBodyError(t *testing.T) {
 
 	fetcher := BasicStockRatingsFetcher{BearerToken: mockTocken}
 
-	_, _, err := fetcher.FetchStockRatings(server.URL)
+	_, err := fetcher.FetchStockRatings(context.Background(), server.URL)
 	if err == nil || err.Error() != "failed to read response body" {
 		t.Fatalf("expected 'failed to read response body', got %v", err)
 	}
@@ -65,7 +68,7 @@ func TestFetchStockData_ParseJsonError(t *testing.T) {
 
 	fetcher := BasicStockRatingsFetcher{BearerToken: mockTocken}
 
-	_, _, err := fetcher.FetchStockRatings(server.URL)
+	_, err := fetcher.FetchStockRatings(context.Background(), server.URL)
 	if err == nil || err.Error() != "failed to parse JSON response" {
 		t.Fatalf("expected 'failed to parse JSON response', got %v", err)
 	}
@@ -98,21 +101,21 @@ func TestFetchStockData_OK(t *testing.T) {
 
 	fetcher := BasicStockRatingsFetcher{BearerToken: mockTocken}
 
-	stocks, nextPage, err := fetcher.FetchStockRatings(server.URL)
+	page, err := fetcher.FetchStockRatings(context.Background(), server.URL)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	if len(stocks) != 1 {
-		t.Fatalf("expected 1 stock, got %d", len(stocks))
+	if len(page.Ratings) != 1 {
+		t.Fatalf("expected 1 stock, got %d", len(page.Ratings))
 	}
 
-	if stocks[0].Ticker != "BSBR" {
-		t.Errorf("expected ticker BSBR, got %s", stocks[0].Ticker)
+	if page.Ratings[0].Ticker != "BSBR" {
+		t.Errorf("expected ticker BSBR, got %s", page.Ratings[0].Ticker)
 	}
 
-	if nextPage != "AZEK" {
-		t.Errorf("expected next_page AZEK, got %s", nextPage)
+	if page.NextPage != "AZEK" {
+		t.Errorf("expected next_page AZEK, got %s", page.NextPage)
 	}
 }
 
@@ -123,7 +126,7 @@ func TestSaveStockData(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to connect to database: %v", err)
 	}
-	_ = db.AutoMigrate(&models.StockRating{})
+	_ = db.AutoMigrate(&models.Stock{}, &models.StockRating{}, &models.StockRatingRevision{}, &models.Brokerage{}, &models.BrokerageAlias{})
 
 	fetcher := BasicStockRatingsFetcher{DB: db, BearerToken: mockTocken}
 
@@ -132,7 +135,7 @@ func TestSaveStockData(t *testing.T) {
 		{Ticker: "GOOGL"},
 	}
 
-	err = fetcher.SaveStockRatings(stockList)
+	err = fetcher.SaveStockRatings(context.Background(), stockList)
 	if err != nil {
 		t.Fatalf("unexpected error saving data: %v", err)
 	}
@@ -153,7 +156,7 @@ func TestFetchStockData_MissingBearerToken(t *testing.T) {
 	defer server.Close()
 
 	fetcher := BasicStockRatingsFetcher{}
-	_, _, err := fetcher.FetchStockRatings(server.URL)
+	_, err := fetcher.FetchStockRatings(context.Background(), server.URL)
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "no bearer token provided")
@@ -200,12 +203,12 @@ func TestFetchAll_MultiplePages(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to connect to database: %v", err)
 	}
-	_ = db.AutoMigrate(&models.StockRating{})
+	_ = db.AutoMigrate(&models.Stock{}, &models.StockRating{}, &models.StockRatingRevision{}, &models.Brokerage{}, &models.BrokerageAlias{}, &models.RatingsSyncState{})
 
 	fetcher := BasicStockRatingsFetcher{DB: db, BearerToken: mockTocken}
 
 	var tickers []string
-	tickers, err = fetcher.FetchAllRatings(server.URL)
+	tickers, _, err = fetcher.FetchAllRatings(context.Background(), server.URL)
 	assert.NoError(t, err)
 
 	var stocks []models.StockRating
@@ -228,11 +231,513 @@ func TestFetchAll_FailsOnBadPage(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to connect to database: %v", err)
 	}
-	_ = db.AutoMigrate(&models.StockRating{})
+	_ = db.AutoMigrate(&models.Stock{}, &models.StockRating{}, &models.StockRatingRevision{}, &models.Brokerage{}, &models.BrokerageAlias{}, &models.RatingsSyncState{})
 
 	fetcher := BasicStockRatingsFetcher{DB: db, BearerToken: mockTocken}
 
-	_, err = fetcher.FetchAllRatings(server.URL)
+	_, report, err := fetcher.FetchAllRatings(context.Background(), server.URL)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "received invalid response from API")
+	assert.Len(t, report.FailedPages, 1)
+}
+
+// --- TEST CASE 10: Malformed rows are skipped, the rest of the page is kept ---
+func TestFetchStockData_SkipsMalformedRows(t *testing.T) {
+	mockResponse := `{
+		"items": [
+			{"ticker": "BSBR", "target_from": "N/A", "target_to": "$4.70", "time": "2025-01-13T00:30:05.813548892Z"},
+			{"ticker": "VYGR", "target_from": "$11.00", "target_to": "$9.00", "time": "2025-01-14T00:30:05.813548892Z"},
+			{"ticker": "AZEK", "target_from": "$1.00", "target_to": "$2.00", "time": "yesterday"}
+		],
+		"next_page": ""
+	}`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(mockResponse))
+	}))
+	defer server.Close()
+
+	fetcher := BasicStockRatingsFetcher{BearerToken: mockTocken}
+
+	page, err := fetcher.FetchStockRatings(context.Background(), server.URL)
+	assert.NoError(t, err)
+	assert.Len(t, page.Ratings, 1)
+	assert.Equal(t, "VYGR", page.Ratings[0].Ticker)
+	assert.Len(t, page.Skipped, 2)
+	assert.Contains(t, page.Skipped[0].Item, "BSBR")
+	assert.Contains(t, page.Skipped[1].Item, "AZEK")
+}
+
+// --- TEST CASE 11: FetchAll keeps going past failing tickers and reports them ---
+func TestStockFetcher_FetchAllReport(t *testing.T) {
+	ratingsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"items": [
+			{"ticker": "DLST", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "A", "time": "2025-01-13T00:30:05Z"},
+			{"ticker": "AAPL", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "A", "time": "2025-01-13T00:30:05Z"},
+			{"ticker": "BAD", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "A", "time": "2025-01-13T00:30:05Z"},
+			{"ticker": "MSFT", "target_from": "N/A", "target_to": "$2.00", "brokerage": "A", "time": "2025-01-13T00:30:05Z"}
+		], "next_page": ""}`))
+	}))
+	defer ratingsServer.Close()
+
+	infoServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch ticker := r.URL.Query().Get("tickers"); ticker {
+		case "DLST":
+			_, _ = w.Write([]byte(`[]`))
+		case "BAD":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			_, _ = w.Write([]byte(`[{"ticker":"` + ticker + `"}]`))
+		}
+	}))
+	defer infoServer.Close()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	_ = db.AutoMigrate(&models.Stock{}, &models.PriceSnapshot{}, &models.StockRating{}, &models.StockRatingRevision{}, &models.Brokerage{}, &models.BrokerageAlias{}, &models.RatingsSyncState{})
+
+	stockFetcher := StockFetcher{
+		RatingsFetcher: &BasicStockRatingsFetcher{DB: db, BearerToken: mockTocken},
+		InfoFetcher:    &BasicStockInfoFetcher{DB: db, BearerToken: mockTocken},
+	}
+
+	report, err := stockFetcher.FetchAll(context.Background(), ratingsServer.URL, infoServer.URL)
+	assert.NoError(t, err)
+	assert.Len(t, report.SucceededPages, 1)
+	assert.Len(t, report.SkippedRatings, 1)
+	assert.Equal(t, []string{"AAPL"}, report.SucceededTickers)
+	assert.Len(t, report.SkippedTickers, 1)
+	assert.Equal(t, "DLST", report.SkippedTickers[0].Item)
+	assert.Len(t, report.FailedTickers, 1)
+	assert.Equal(t, "BAD", report.FailedTickers[0].Item)
+	assert.True(t, report.HasFailures())
+
+	// the tickers without info keep the placeholder stock their ratings created
+	var stocks []models.Stock
+	db.Order("ticker").Find(&stocks)
+	assert.Len(t, stocks, 3)
+}
+
+// --- TEST CASE 12: Incremental syncs resume from the persisted cursor ---
+func TestFetchAllRatings_Incremental(t *testing.T) {
+	pages := map[string]string{
+		"": `{"items": [
+			{"ticker": "BSBR", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "A", "time": "2025-01-13T00:30:05Z"}
+		], "next_page": "VYGR"}`,
+		"VYGR": `{"items": [
+			{"ticker": "VYGR", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "A", "time": "2025-01-14T00:30:05Z"}
+		], "next_page": ""}`,
+	}
+	var requested []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("next_page")
+		requested = append(requested, page)
+		_, _ = w.Write([]byte(pages[page]))
+	}))
+	defer server.Close()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	_ = db.AutoMigrate(&models.Stock{}, &models.StockRating{}, &models.StockRatingRevision{}, &models.Brokerage{}, &models.BrokerageAlias{}, &models.RatingsSyncState{})
+
+	fetcher := BasicStockRatingsFetcher{DB: db, BearerToken: mockTocken, Incremental: true}
+
+	// first run goes through the whole feed
+	_, _, err = fetcher.FetchAllRatings(context.Background(), server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"", "VYGR"}, requested)
+
+	state, err := models.GetRatingsSyncState(context.Background(), db, server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "VYGR", state.Cursor)
+	assert.True(t, state.HighWaterMark.Equal(time.Date(2025, 1, 14, 0, 30, 5, 0, time.UTC)))
+
+	// the feed grows: the last page gets a rating older than the high-water mark, which was never saved, and a new
+	// page is appended after it
+	pages["VYGR"] = `{"items": [
+		{"ticker": "VYGR", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "A", "time": "2025-01-14T00:30:05Z"},
+		{"ticker": "OLD", "target_from": "$3.00", "target_to": "$4.00", "brokerage": "B", "time": "2024-01-15T00:30:05Z"}
+	], "next_page": "AZEK"}`
+	pages["AZEK"] = `{"items": [
+		{"ticker": "AZEK", "target_from": "$3.00", "target_to": "$4.00", "brokerage": "B", "time": "2025-01-15T00:30:05Z"}
+	], "next_page": ""}`
+	requested = nil
+
+	tickers, _, err := fetcher.FetchAllRatings(context.Background(), server.URL)
+	assert.NoError(t, err)
+	// the first page isn't fetched again
+	assert.Equal(t, []string{"VYGR", "AZEK"}, requested)
+	// every rated ticker gets its info refreshed
+	assert.Equal(t, []string{"AZEK", "BSBR", "OLD", "VYGR"}, tickers)
+
+	var count int64
+	db.Model(&models.StockRating{}).Where("ticker = ?", "OLD").Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	state, err = models.GetRatingsSyncState(context.Background(), db, server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "AZEK", state.Cursor)
+	assert.True(t, state.HighWaterMark.Equal(time.Date(2025, 1, 15, 0, 30, 5, 0, time.UTC)))
+
+	// nothing changed, so only the last page is fetched again
+	requested = nil
+	_, _, err = fetcher.FetchAllRatings(context.Background(), server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AZEK"}, requested)
+
+	// a full resync starts over
+	requested = nil
+	_, _, err = fetcher.Resync(context.Background(), server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"", "VYGR", "AZEK"}, requested)
+	db.Model(&models.StockRatingRevision{}).Count(&count)
+	assert.Equal(t, int64(4), count)
+}
+
+// --- TEST CASE 13: SaveStockRatings keeps the rating history ---
+func TestSaveStockData_KeepsHistory(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	_ = db.AutoMigrate(&models.Stock{}, &models.StockRating{}, &models.StockRatingRevision{}, &models.Brokerage{}, &models.BrokerageAlias{})
+
+	fetcher := BasicStockRatingsFetcher{DB: db, BearerToken: mockTocken}
+	firstTime := time.Date(2025, 1, 13, 0, 0, 0, 0, time.UTC)
+	secondTime := firstTime.AddDate(0, 1, 0)
+
+	err = fetcher.SaveStockRatings(context.Background(), []models.StockRating{
+		{Ticker: "AAPL", Brokerage: "Wedbush", RatingTo: "Neutral", TargetTo: 200, Time: firstTime},
+		{Ticker: "AAPL", Brokerage: "Wedbush", RatingTo: "Outperform", TargetTo: 250, Time: secondTime},
+	})
+	assert.NoError(t, err)
+
+	// older revisions arriving late, or again, don't replace the current rating
+	err = fetcher.SaveStockRatings(context.Background(), []models.StockRating{
+		{Ticker: "AAPL", Brokerage: "Wedbush", RatingTo: "Neutral", TargetTo: 200, Time: firstTime},
+	})
+	assert.NoError(t, err)
+
+	var history []models.StockRatingRevision
+	db.Order("time").Find(&history)
+	assert.Len(t, history, 2)
+	assert.Equal(t, "Neutral", history[0].RatingTo)
+	assert.Equal(t, "Outperform", history[1].RatingTo)
+
+	var current []models.StockRating
+	db.Find(&current)
+	assert.Len(t, current, 1)
+	assert.Equal(t, "Outperform", current[0].RatingTo)
+	assert.Equal(t, 250.0, current[0].TargetTo)
+}
+
+// --- TEST CASE 14: Ratings are deduplicated by their natural key ---
+func TestSaveStockData_NaturalKey(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	_ = db.AutoMigrate(&models.Stock{}, &models.StockRating{}, &models.StockRatingRevision{}, &models.Brokerage{}, &models.BrokerageAlias{})
+
+	fetcher := BasicStockRatingsFetcher{DB: db, BearerToken: mockTocken}
+	ratingTime := time.Date(2025, 1, 13, 0, 0, 0, 0, time.UTC)
+
+	// two brokerages rating at the same instant, with a repeated row
+	page := []models.StockRating{
+		{Ticker: "AAPL", Brokerage: "A", RatingTo: "Buy", Time: ratingTime},
+		{Ticker: "AAPL", Brokerage: "B", RatingTo: "Sell", Time: ratingTime},
+		{Ticker: "AAPL", Brokerage: "A", RatingTo: "Buy", Time: ratingTime},
+	}
+	assert.NoError(t, fetcher.SaveStockRatings(context.Background(), page))
+	assert.NoError(t, fetcher.SaveStockRatings(context.Background(), page))
+
+	var revisions int64
+	db.Model(&models.StockRatingRevision{}).Count(&revisions)
+	assert.Equal(t, int64(2), revisions)
+
+	var current []models.StockRating
+	db.Order("brokerage").Find(&current)
+	assert.Len(t, current, 2)
+	assert.Equal(t, "Buy", current[0].RatingTo)
+	assert.Equal(t, "Sell", current[1].RatingTo)
+}
+
+// --- TEST CASE 15: Upstream IDs take precedence over the content of the rating ---
+func TestConvertStockRatings_RevisionKey(t *testing.T) {
+	raw := models.StockRatingRaw{Ticker: "AAPL", Brokerage: "A", TargetFrom: "$1.00", TargetTo: "$2.00", Time: "2025-01-13T00:30:05Z"}
+
+	rating, err := convertStockRatingsApiResponse(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, models.StockRatingContentKey(rating), rating.RevisionKey)
+
+	// the content key changes along with the content
+	raw.TargetTo = "$3.00"
+	corrected, err := convertStockRatingsApiResponse(raw)
+	assert.NoError(t, err)
+	assert.NotEqual(t, rating.RevisionKey, corrected.RevisionKey)
+
+	raw.ID = "rating-42"
+	rating, err = convertStockRatingsApiResponse(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, "rating-42", rating.UpstreamID)
+
+	// the ID is namespaced by the name of the source, so the same ID from another source is another rating
+	fromApi := []models.StockRating{rating}
+	keyBySource("https://api.example.com/ratings", fromApi)
+	assert.Equal(t, "id:https://api.example.com/ratings:rating-42", fromApi[0].RevisionKey)
+
+	fromDropdir := []models.StockRating{rating}
+	keyBySource("dropdir", fromDropdir)
+	assert.Equal(t, "id:dropdir:rating-42", fromDropdir[0].RevisionKey)
+}
+
+// --- TEST CASE 16: Conditional requests skip unchanged pages ---
+func TestFetchAllRatings_ConditionalRequests(t *testing.T) {
+	pages := map[string]string{
+		"":     `{"items": [{"ticker": "BSBR", "target_from": "$4.20", "target_to": "$4.70", "brokerage": "The Goldman Sachs Group", "time": "2025-01-13T00:30:05Z"}], "next_page": "VYGR"}`,
+		"VYGR": `{"items": [{"ticker": "VYGR", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "A", "time": "2025-01-14T00:30:05Z"}], "next_page": ""}`,
+	}
+	var conditional []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("next_page")
+		if r.Header.Get("If-Modified-Since") != "" {
+			conditional = append(conditional, page)
+		}
+		// the first page never changes, the last one does
+		if page == "" && r.Header.Get("If-None-Match") == `"first"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if page == "" {
+			w.Header().Set("ETag", `"first"`)
+		}
+		w.Header().Set("Last-Modified", "Mon, 13 Jan 2025 00:30:05 GMT")
+		_, _ = w.Write([]byte(pages[page]))
+	}))
+	defer server.Close()
+
+	db := models.NewTestDB(nil)
+	fetcher := BasicStockRatingsFetcher{DB: db, BearerToken: mockTocken, ConditionalRequests: true}
+
+	_, report, err := fetcher.FetchAllRatings(context.Background(), server.URL)
+	assert.NoError(t, err)
+	assert.Len(t, report.SucceededPages, 2)
+	assert.Empty(t, conditional)
+
+	// an unchanged page is not saved again, yet the sync goes on to the page it pointed to
+	db.Where("1 = 1").Delete(&models.StockRating{})
+	tickers, report, err := fetcher.FetchAllRatings(context.Background(), server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"", "VYGR"}, conditional)
+	assert.Len(t, report.UnchangedPages, 1)
+	assert.Len(t, report.SucceededPages, 1)
+	assert.Equal(t, []string{"VYGR"}, tickers)
+
+	var count int64
+	db.Model(&models.StockRating{}).Where("ticker = ?", "BSBR").Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+// --- TEST CASE 17: StreamStockRatings hands the items over in bounded batches, wherever next_page is ---
+func TestStreamStockRatings_Batches(t *testing.T) {
+	const total = 2*ratingsStreamBatchSize + 7
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"extra": {"ignored": [1, 2]}, "items": [`))
+		for i := 0; i < total; i++ {
+			if i > 0 {
+				_, _ = w.Write([]byte(","))
+			}
+			_, _ = fmt.Fprintf(w, `{"ticker": "T%d", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "B", "time": "2025-01-13T00:30:05Z"}`, i)
+		}
+		_, _ = w.Write([]byte(`, {"ticker": "BAD", "target_from": "oops"}], "next_page": "NEXT"}`))
+	}))
+	defer server.Close()
+
+	fetcher := BasicStockRatingsFetcher{BearerToken: mockTocken}
+	var sizes []int
+	count := 0
+	page, err := fetcher.StreamStockRatings(context.Background(), server.URL, func(batch []models.StockRating) error {
+		sizes = append(sizes, len(batch))
+		count += len(batch)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{ratingsStreamBatchSize, ratingsStreamBatchSize, 7}, sizes)
+	assert.Equal(t, total, count)
+	assert.Equal(t, "NEXT", page.NextPage)
+	assert.Empty(t, page.Ratings)
+	assert.Len(t, page.Skipped, 1)
+	assert.Contains(t, page.Skipped[0].Item, fmt.Sprintf("row %d (BAD)", total))
+
+	// a null items array is an empty page
+	nullServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"items": null, "next_page": ""}`))
+	}))
+	defer nullServer.Close()
+	page, err = fetcher.FetchStockRatings(context.Background(), nullServer.URL)
+	assert.NoError(t, err)
+	assert.Empty(t, page.Ratings)
+}
+
+// --- TEST CASE 18: A page streamed into the database is saved atomically ---
+func TestFetchAllRatings_StreamedPageIsAtomic(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"items": [`))
+		for i := 0; i < ratingsStreamBatchSize; i++ {
+			_, _ = fmt.Fprintf(w, `{"ticker": "T%d", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "B", "time": "2025-01-13T00:30:05Z"},`, i)
+		}
+		// the connection breaks after the first batch was written
+		_, _ = w.Write([]byte(`{"ticker": "T`))
+	}))
+	defer server.Close()
+
+	db := models.NewTestDB(nil)
+	fetcher := BasicStockRatingsFetcher{DB: db, BearerToken: mockTocken}
+
+	_, report, err := fetcher.FetchAllRatings(context.Background(), server.URL)
+	assert.Error(t, err)
+	assert.Len(t, report.FailedPages, 1)
+
+	var count int64
+	db.Model(&models.StockRatingRevision{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+// --- TEST CASE 19: Targets keep the currency they are given in ---
+func TestParseMoneyValue(t *testing.T) {
+	cases := []struct {
+		value    string
+		amount   float64
+		currency string
+	}{
+		{"$4.20", 4.20, "USD"},
+		{"€12.50", 12.50, "EUR"},
+		{"£3,100", 3100, "GBP"},
+		{"12.50 CHF", 12.50, "CHF"},
+		{"C$7", 7, "CAD"},
+		{"1,250.5", 1250.5, "USD"},
+	}
+	for _, c := range cases {
+		amount, currency, err := parseMoneyValue(c.value)
+		assert.NoError(t, err, c.value)
+		assert.Equal(t, c.amount, amount, c.value)
+		assert.Equal(t, c.currency, currency, c.value)
+	}
+
+	_, _, err := parseMoneyValue("N/A")
+	assert.Error(t, err)
+
+	raw := models.StockRatingRaw{Ticker: "SAP", Brokerage: "A", TargetFrom: "€100", TargetTo: "€110", Time: "2025-01-13T00:30:05Z"}
+	rating, err := convertStockRatingsApiResponse(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, "EUR", rating.Currency)
+
+	raw.TargetTo = "$110"
+	_, err = convertStockRatingsApiResponse(raw)
+	assert.Error(t, err, "targets in different currencies can't be compared")
+}
+
+// --- TEST CASE 20: Ratings are saved under the canonical name of their brokerage ---
+func TestSaveStockData_CanonicalBrokerage(t *testing.T) {
+	db := models.NewTestDB(nil)
+	fetcher := BasicStockRatingsFetcher{DB: db}
+
+	ratings := []models.StockRating{
+		{Ticker: "AAPL", Brokerage: "Wells Fargo & Company", RatingTo: "Buy", Time: time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)},
+		{Ticker: "AAPL", Brokerage: "Wells Fargo", RatingTo: "Hold", Time: time.Date(2025, 1, 11, 0, 0, 0, 0, time.UTC)},
+	}
+	assert.NoError(t, fetcher.SaveStockRatings(context.Background(), ratings))
+
+	var current []models.StockRating
+	db.Find(&current)
+	assert.Len(t, current, 1, "a brokerage gets a single vote")
+	assert.Equal(t, "Wells Fargo & Company", current[0].Brokerage)
+	assert.Equal(t, "Hold", current[0].RatingTo)
+	assert.Equal(t, models.ActionUnknown, current[0].ActionType)
+
+	// the revisions keep the keys of the names the feed gave
+	var revision models.StockRatingRevision
+	db.Where("rating_to = ?", "Hold").First(&revision)
+	assert.Equal(t, models.StockRatingContentKey(ratings[1]), revision.RevisionKey)
+}
+
+// --- TEST CASE 21: Saved ratings get the type of their action ---
+func TestSaveStockData_ActionType(t *testing.T) {
+	db := models.NewTestDB(nil)
+	fetcher := BasicStockRatingsFetcher{DB: db}
+
+	err := fetcher.SaveStockRatings(context.Background(), []models.StockRating{
+		{Ticker: "AAPL", Brokerage: "A", Action: "target lowered by", TargetFrom: 250, TargetTo: 230},
+		{Ticker: "AAPL", Brokerage: "B", Action: "upgraded by"},
+	})
+	assert.NoError(t, err)
+
+	var current []models.StockRating
+	db.Order("brokerage").Find(&current)
+	assert.Equal(t, models.ActionTargetCut, current[0].ActionType)
+	assert.Equal(t, models.ActionUpgrade, current[1].ActionType)
+}
+
+// --- TEST CASE 22: Tickers are saved normalized, and junk tickers are rejected ---
+func TestFetchAllRatings_NormalizesTickers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"items": [
+			{"ticker": " brk-b ", "target_from": "$400", "target_to": "$450", "brokerage": "A", "time": "2025-01-13T00:30:05Z"},
+			{"ticker": "BRK/A", "target_from": "$400", "target_to": "$450", "brokerage": "B", "time": "2025-01-13T00:30:05Z"},
+			{"ticker": "N/A", "target_from": "$1", "target_to": "$2", "brokerage": "A", "time": "2025-01-13T00:30:05Z"},
+			{"ticker": "", "target_from": "$1", "target_to": "$2", "brokerage": "A", "time": "2025-01-13T00:30:05Z"}
+		], "next_page": ""}`))
+	}))
+	defer server.Close()
+
+	db := models.NewTestDB(nil)
+	fetcher := BasicStockRatingsFetcher{DB: db, BearerToken: mockTocken}
+
+	tickers, report, err := fetcher.FetchAllRatings(context.Background(), server.URL)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"BRK.B", "BRK.A"}, tickers)
+	assert.Len(t, report.SkippedRatings, 2)
+	assert.ErrorIs(t, report.SkippedRatings[0].Err, models.ErrInvalidTicker)
+
+	var saved []string
+	db.Model(&models.StockRating{}).Order("ticker").Pluck("ticker", &saved)
+	assert.Equal(t, []string{"BRK.A", "BRK.B"}, saved)
+}
+
+// --- TEST CASE 23: Ratings arriving before the info of their stock create a placeholder stock ---
+func TestFetchAll_PlaceholderStocks(t *testing.T) {
+	ratingsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"items": [
+			{"ticker": "AAPL", "company": "Apple", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "A", "time": "2025-01-13T00:30:05Z"},
+			{"ticker": "DLST", "company": "Delisted Corp", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "A", "time": "2025-01-13T00:30:05Z"}
+		], "next_page": ""}`))
+	}))
+	defer ratingsServer.Close()
+
+	infoServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("tickers") == "AAPL" {
+			_, _ = w.Write([]byte(`[{"ticker":"AAPL","lastPrice":214.65,"companyName":"Apple Inc."}]`))
+			return
+		}
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer infoServer.Close()
+
+	db := models.NewTestDB(nil)
+	stockFetcher := StockFetcher{
+		RatingsFetcher: &BasicStockRatingsFetcher{DB: db, BearerToken: mockTocken},
+		InfoFetcher:    &BasicStockInfoFetcher{DB: db, BearerToken: mockTocken},
+	}
+
+	_, err := stockFetcher.FetchAll(context.Background(), ratingsServer.URL, infoServer.URL)
+	assert.NoError(t, err)
+
+	var stocks []models.Stock
+	db.Order("ticker").Find(&stocks)
+	assert.Len(t, stocks, 2)
+	assert.Equal(t, "Apple Inc.", stocks[0].Company)
+	assert.Equal(t, 214.65, stocks[0].LastPrice)
+	assert.Equal(t, "Delisted Corp", stocks[1].Company)
+	assert.Equal(t, 0.0, stocks[1].LastPrice)
+
+	var ratings int64
+	db.Model(&models.StockRating{}).Count(&ratings)
+	assert.Equal(t, int64(2), ratings)
 }
@@ -0,0 +1,263 @@
+package fetcher
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Modes of the upstream fixtures
+const (
+	// FixturesRecord sends the requests to the upstreams and writes every response to the fixtures
+	FixturesRecord = "record"
+	// FixturesReplay serves the responses from the fixtures, without calling the upstreams
+	FixturesReplay = "replay"
+)
+
+// ErrNoFixture is returned when replaying a request that was never recorded
+var ErrNoFixture = errors.New("no recorded response")
+
+// DefaultFixtureIgnoredParams are the query parameters left out of the fixtures unless configured otherwise, as they
+// usually carry credentials
+var DefaultFixtureIgnoredParams = []string{"api_key", "apikey", "token", "access_token"}
+
+// fixtureHeaders are the response headers kept in the fixtures, the ones the fetchers read
+var fixtureHeaders = []string{"Content-Type", "ETag", "Last-Modified", "Retry-After"}
+
+// Fixtures records the traffic of the upstreams to a directory, a JSON file per request, or replays it from there.
+// Requests are told apart by their method and URL, so replaying a request gives the last response recorded for it.
+// Request headers are never recorded, and neither are the IgnoredParams, so the fixtures hold no credentials and
+// can be replayed with any
+type Fixtures struct {
+	Dir  string
+	Mode string
+	// IgnoredParams are the query parameters left out of the fixtures. Defaults to DefaultFixtureIgnoredParams
+	IgnoredParams []string
+}
+
+// NewFixtures sets up the fixtures of the given mode in a directory. An empty mode or "off" gives no fixtures, so
+// the upstreams are called as usual. Recording creates the directory, while replaying needs it to exist
+func NewFixtures(mode string, dir string) (*Fixtures, error) {
+	switch mode {
+	case "", "off":
+		return nil, nil
+	case FixturesRecord:
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create the fixtures directory: %w", err)
+		}
+	case FixturesReplay:
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("the fixtures directory %s doesn't exist", dir)
+		}
+	default:
+		return nil, fmt.Errorf("unknown fixtures mode %q, expected one of off, record and replay", mode)
+	}
+	return &Fixtures{Dir: dir, Mode: mode}, nil
+}
+
+// IgnoreCredentials leaves the query parameters an authenticator sets its credentials in out of the fixtures too, so
+// credentials in custom parameters aren't recorded either
+func (f *Fixtures) IgnoreCredentials(auth IAuthenticator) {
+	query, ok := auth.(IQueryAuthenticator)
+	if f == nil || !ok {
+		return
+	}
+	if f.IgnoredParams == nil {
+		f.IgnoredParams = slices.Clone(DefaultFixtureIgnoredParams)
+	}
+	for _, param := range query.QueryParams() {
+		if !slices.Contains(f.IgnoredParams, param) {
+			f.IgnoredParams = append(f.IgnoredParams, param)
+		}
+	}
+}
+
+// fixture is a recorded response. JSON bodies are kept as they are, so the fixtures can be read and edited
+type fixture struct {
+	Method     string          `json:"method"`
+	URL        string          `json:"url"`
+	Status     int             `json:"status"`
+	Header     http.Header     `json:"header,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"`
+	BodyText   string          `json:"body_text,omitempty"`
+	RecordedAt time.Time       `json:"recorded_at"`
+}
+
+// Transport wraps a round tripper so requests are recorded or replayed. Nil fixtures let every request through
+func (f *Fixtures) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if f == nil {
+		return base
+	}
+	return &fixturesTransport{fixtures: f, base: base}
+}
+
+type fixturesTransport struct {
+	fixtures *Fixtures
+	base     http.RoundTripper
+}
+
+func (t *fixturesTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.fixtures.Mode == FixturesReplay {
+		return t.fixtures.replay(req)
+	}
+	return t.fixtures.record(t.base, req)
+}
+
+// record sends the request and writes its response to the fixtures. The response is recorded in full, so the
+// conditional headers are dropped. Transient failures aren't recorded, as they would shadow the response recorded
+// before. Failing to write the fixture doesn't fail the request
+func (f *Fixtures) record(base http.RoundTripper, req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+
+	resp, err := base.RoundTrip(req)
+	if err != nil || retryableStatusCodes[resp.StatusCode] {
+		return resp, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	method, url := f.requestKey(req)
+	recorded := fixture{Method: method, URL: url, Status: resp.StatusCode, Header: http.Header{}, RecordedAt: time.Now().UTC()}
+	for _, name := range fixtureHeaders {
+		if values := resp.Header.Values(name); len(values) > 0 {
+			recorded.Header[name] = values
+		}
+	}
+	if json.Valid(body) {
+		recorded.Body = body
+	} else {
+		recorded.BodyText = string(body)
+	}
+
+	if err := f.write(recorded); err != nil {
+		log.Printf("Failed to record the response of %s %s: %v", method, url, err)
+	}
+	return resp, nil
+}
+
+// write saves a fixture, replacing the one recorded before for the same request
+func (f *Fixtures) write(recorded fixture) error {
+	content, err := json.MarshalIndent(recorded, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// written aside and renamed, so concurrent requests never replay half a fixture
+	path := filepath.Join(f.Dir, fixtureName(recorded.Method, recorded.URL))
+	tmp, err := os.CreateTemp(f.Dir, ".fixture-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(content, '\n')); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// replay serves the recorded response of the request. A request carrying the validator of the recorded response
+// gets a 304, as the upstream would answer
+func (f *Fixtures) replay(req *http.Request) (*http.Response, error) {
+	method, url := f.requestKey(req)
+	content, err := os.ReadFile(filepath.Join(f.Dir, fixtureName(method, url)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w for %s %s", ErrNoFixture, method, url)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var recorded fixture
+	if err := json.Unmarshal(content, &recorded); err != nil {
+		return nil, fmt.Errorf("invalid fixture for %s %s: %w", method, url, err)
+	}
+
+	status, body := recorded.Status, []byte(recorded.BodyText)
+	if len(recorded.Body) > 0 {
+		body = recorded.Body
+	}
+	etag := recorded.Header.Get("ETag")
+	if etag != "" && req.Header.Get("If-None-Match") == etag {
+		status, body = http.StatusNotModified, nil
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        recorded.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// requestKey gets the method and URL identifying a request in the fixtures. The ignored parameters are left out
+// of the URL and the rest are sorted, so the URL doesn't depend on the credentials nor on the order of the query
+func (f *Fixtures) requestKey(req *http.Request) (string, string) {
+	ignored := f.IgnoredParams
+	if ignored == nil {
+		ignored = DefaultFixtureIgnoredParams
+	}
+
+	u := *req.URL
+	query := u.Query()
+	for param := range query {
+		if slices.Contains(ignored, param) {
+			query.Del(param)
+		}
+	}
+	u.RawQuery = query.Encode()
+	u.User = nil
+	u.Fragment = ""
+	return req.Method, u.String()
+}
+
+// unsafeFixtureChars are the characters replaced in the names of the fixtures
+var unsafeFixtureChars = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// fixtureName names the fixture of a request after its URL, so the fixtures can be told apart, followed by a hash
+// of the request, so names never collide
+func fixtureName(method string, url string) string {
+	readable := strings.TrimPrefix(strings.TrimPrefix(url, "https://"), "http://")
+	readable = strings.Trim(unsafeFixtureChars.ReplaceAllString(readable, "_"), "_")
+	if len(readable) > 80 {
+		readable = readable[:80]
+	}
+
+	hash := sha256.Sum256([]byte(method + " " + url))
+	return strings.ToLower(method) + "_" + readable + "_" + hex.EncodeToString(hash[:6]) + ".json"
+}
@@ -0,0 +1,136 @@
+package fetcher
+
+import (
+	"context"
+	"github.com/c4ts0up/my-stocks/backend/models"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// --- TEST CASE 1: Recorded traffic is replayed without calling the upstreams ---
+func TestFixtures_RecordAndReplay(t *testing.T) {
+	ratingsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"items": [
+			{"ticker": "AAPL", "company": "Apple", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "A", "time": "2025-01-13T00:30:05Z"}
+		], "next_page": ""}`))
+	}))
+	infoServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"ticker":"AAPL","lastPrice":214.65,"companyName":"Apple Inc."}]`))
+	}))
+
+	dir := t.TempDir()
+	fetchAll := func(fixtures *Fixtures) *FetchReport {
+		db := models.NewTestDB(nil)
+		auth := &QueryApiKeyAuth{Param: "api_key", Tokens: NewTokenRotation([]string{"secret-token"})}
+		stockFetcher := StockFetcher{
+			RatingsFetcher: &BasicStockRatingsFetcher{DB: db, Auth: auth, Fixtures: fixtures},
+			InfoFetcher:    &BasicStockInfoFetcher{DB: db, Auth: auth, Fixtures: fixtures},
+		}
+		report, err := stockFetcher.FetchAll(context.Background(), ratingsServer.URL, infoServer.URL)
+		assert.NoError(t, err)
+
+		var stock models.Stock
+		assert.NoError(t, db.Where("ticker = ?", "AAPL").First(&stock).Error)
+		assert.Equal(t, 214.65, stock.LastPrice)
+		return report
+	}
+
+	recorder, err := NewFixtures(FixturesRecord, dir)
+	assert.NoError(t, err)
+	fetchAll(recorder)
+
+	// the upstreams are gone, only the fixtures are left
+	ratingsServer.Close()
+	infoServer.Close()
+
+	replayer, err := NewFixtures(FixturesReplay, dir)
+	assert.NoError(t, err)
+	report := fetchAll(replayer)
+	assert.Equal(t, []string{"AAPL"}, report.SucceededTickers)
+	assert.Equal(t, 1, report.SavedRatings)
+
+	// the fixtures hold no credentials
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	for _, entry := range entries {
+		content, err := os.ReadFile(dir + "/" + entry.Name())
+		assert.NoError(t, err)
+		assert.NotContains(t, string(content), "secret-token")
+	}
+}
+
+// --- TEST CASE 2: Replaying a request that was never recorded fails, and validators get a 304 ---
+func TestFixtures_Replay(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(dir+"/"+fixtureName("GET", "https://info.example.com/stocks?tickers=AAPL"), []byte(`{
+		"method": "GET",
+		"url": "https://info.example.com/stocks?tickers=AAPL",
+		"status": 200,
+		"header": {"Etag": ["\"v1\""]},
+		"body": [{"ticker": "AAPL", "lastPrice": 214.65}]
+	}`), 0o644)
+	assert.NoError(t, err)
+
+	fixtures, err := NewFixtures(FixturesReplay, dir)
+	assert.NoError(t, err)
+	client := newHttpClient(0, nil, nil, fixtures)
+
+	// the query is matched whatever its order and credentials
+	req, _ := http.NewRequest("GET", "https://info.example.com/stocks?token=abc&tickers=AAPL", nil)
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"ticker": "AAPL", "lastPrice": 214.65}]`, string(body))
+
+	req.Header.Set("If-None-Match", `"v1"`)
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	req, _ = http.NewRequest("GET", "https://info.example.com/stocks?tickers=MSFT", nil)
+	_, err = client.Do(req)
+	assert.ErrorIs(t, err, ErrNoFixture)
+
+	_, err = NewFixtures(FixturesReplay, dir+"/missing")
+	assert.Error(t, err)
+	_, err = NewFixtures("rewind", dir)
+	assert.Error(t, err)
+}
+
+// --- TEST CASE 3: The query parameters of the authenticators are left out of the fixtures ---
+func TestFixtures_IgnoreCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"ticker":"AAPL","lastPrice":214.65}]`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	fixtures, err := NewFixtures(FixturesRecord, dir)
+	assert.NoError(t, err)
+	auth := &QueryApiKeyAuth{Param: "sig", Tokens: NewTokenRotation([]string{"secret-token"})}
+	fixtures.IgnoreCredentials(auth)
+	assert.Contains(t, fixtures.IgnoredParams, "api_key")
+
+	req, _ := http.NewRequest("GET", server.URL+"/stocks?tickers=AAPL", nil)
+	assert.NoError(t, auth.Authenticate(req))
+	_, err = newHttpClient(0, nil, nil, fixtures).Do(req)
+	assert.NoError(t, err)
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.NotContains(t, entries[0].Name(), "secret-token")
+	assert.NotContains(t, entries[0].Name(), "sig")
+	assert.Equal(t, fixtureName("GET", server.URL+"/stocks?tickers=AAPL"), entries[0].Name())
+	content, err := os.ReadFile(dir + "/" + entries[0].Name())
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "secret-token")
+	assert.Contains(t, string(content), `"url": "`+server.URL+`/stocks?tickers=AAPL"`)
+}
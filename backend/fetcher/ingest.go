@@ -0,0 +1,91 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/c4ts0up/my-stocks/backend/models"
+	"gorm.io/gorm"
+	"log"
+)
+
+// IngestSource is the source the pushed rating rows are quarantined under, and keyed by
+const IngestSource = "ingest"
+
+// ingestSource names the source of the rows pushed by a vendor. The rows of each vendor are namespaced apart, as their
+// IDs are only unique within the vendor
+func ingestSource(vendor string) string {
+	if vendor == "" {
+		return IngestSource
+	}
+	return IngestSource + ":" + vendor
+}
+
+// IngestResult is the outcome of a pushed rating row
+type IngestResult struct {
+	Row    int
+	Ticker string
+	// Accepted tells the row was converted and saved. Otherwise Err tells why it wasn't
+	Accepted bool
+	// Duplicate tells an accepted row was already in the rating history
+	Duplicate bool
+	Err       error
+}
+
+// IngestRatings converts and saves rating rows pushed by a vendor, in the StockRatingRaw shape, like the rows of a
+// fetched page. Each row is converted on its own, so a malformed row is rejected and quarantined without affecting
+// the others. The accepted rows are saved in a single transaction. Also returns the tickers that got new ratings
+func (s *BasicStockRatingsFetcher) IngestRatings(ctx context.Context, vendor string, rows []json.RawMessage) ([]IngestResult, []string, error) {
+	source := ingestSource(vendor)
+	page := StockRatingsPage{}
+	results := make([]IngestResult, len(rows))
+	rowOf := make([]int, 0, len(rows))
+	for i, row := range rows {
+		results[i] = IngestResult{Row: i}
+
+		// a row of the wrong type is rejected along with the fields decoded before the mismatch, like the ticker
+		var raw models.StockRatingRaw
+		var rating models.StockRating
+		err := json.Unmarshal(row, &raw)
+		if err == nil {
+			rating, err = convertStockRatingsApiResponse(raw)
+		}
+		results[i].Ticker = raw.Ticker
+		if err != nil {
+			page.reject(source, i, raw.Ticker, models.RatingFormatApi, row, err)
+			results[i].Err = page.Skipped[len(page.Skipped)-1].Err
+			continue
+		}
+		page.Ratings = append(page.Ratings, rating)
+		rowOf = append(rowOf, i)
+	}
+	s.quarantine(ctx, source, page.Quarantined)
+	keyBySource(source, page.Ratings)
+
+	var tickers []string
+	seen := make(map[string]bool)
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		writer := newRatingsWriter(tx)
+		for j, rating := range page.Ratings {
+			isNew, err := writer.writeRating(rating)
+			if err != nil {
+				return err
+			}
+			results[rowOf[j]].Duplicate = !isNew
+			if isNew && !seen[rating.Ticker] {
+				seen[rating.Ticker] = true
+				tickers = append(tickers, rating.Ticker)
+			}
+		}
+		return writer.finish()
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to save the pushed ratings: %w", err)
+	}
+
+	for _, i := range rowOf {
+		results[i].Accepted = true
+	}
+	log.Printf("Ingested %d of %d pushed rating rows, %d tickers got new ratings", len(rowOf), len(rows), len(tickers))
+	return results, tickers, nil
+}
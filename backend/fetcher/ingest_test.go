@@ -0,0 +1,66 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/c4ts0up/my-stocks/backend/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// --- TEST CASE 1: Pushed rows are accepted or rejected one by one, and only new ratings refresh their tickers ---
+func TestIngestRatings(t *testing.T) {
+	var rows []json.RawMessage
+	err := json.Unmarshal([]byte(`[
+		{"ticker": "BSBR", "target_from": "$4.20", "target_to": "$4.70", "brokerage": "A", "time": "2025-01-13T00:30:05Z"},
+		{"ticker": "NA", "target_from": "N/A", "target_to": "$4.70", "brokerage": "A", "time": "2025-01-13T00:30:05Z"},
+		{"ticker": "TYPE", "target_from": 4.2, "target_to": "$4.70", "brokerage": "A", "time": "2025-01-13T00:30:05Z"}
+	]`), &rows)
+	assert.NoError(t, err)
+
+	db := models.NewTestDB(nil)
+	fetcher := BasicStockRatingsFetcher{DB: db}
+
+	results, tickers, err := fetcher.IngestRatings(context.Background(), "", rows)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"BSBR"}, tickers)
+	assert.Len(t, results, 3)
+	assert.True(t, results[0].Accepted)
+	assert.False(t, results[0].Duplicate)
+	assert.False(t, results[1].Accepted)
+	assert.Contains(t, results[1].Err.Error(), "failed to parse stock data")
+	assert.False(t, results[2].Accepted)
+	assert.Equal(t, "TYPE", results[2].Ticker)
+
+	var current models.StockRating
+	assert.NoError(t, db.Where("ticker = ?", "BSBR").First(&current).Error)
+	assert.Equal(t, 4.70, current.TargetTo)
+
+	var quarantined []models.QuarantinedRating
+	db.Where("source = ?", IngestSource).Find(&quarantined)
+	assert.Len(t, quarantined, 2)
+
+	// pushing the same batch again changes nothing
+	results, tickers, err = fetcher.IngestRatings(context.Background(), "", rows[:1])
+	assert.NoError(t, err)
+	assert.Empty(t, tickers)
+	assert.True(t, results[0].Accepted)
+	assert.True(t, results[0].Duplicate)
+}
+
+// --- TEST CASE 2: The IDs pushed by different vendors are different ratings ---
+func TestIngestRatings_Vendors(t *testing.T) {
+	row := json.RawMessage(`{"id": "42", "ticker": "BSBR", "target_from": "$4.20", "target_to": "$4.70", "brokerage": "A", "time": "2025-01-13T00:30:05Z"}`)
+
+	db := models.NewTestDB(nil)
+	fetcher := BasicStockRatingsFetcher{DB: db}
+
+	for _, vendor := range []string{"acme", "globex", "acme"} {
+		_, _, err := fetcher.IngestRatings(context.Background(), vendor, []json.RawMessage{row})
+		assert.NoError(t, err)
+	}
+
+	var keys []string
+	db.Model(&models.StockRatingRevision{}).Order("revision_key").Pluck("revision_key", &keys)
+	assert.Equal(t, []string{"id:ingest:acme:42", "id:ingest:globex:42"}, keys)
+}
@@ -0,0 +1,83 @@
+package fetcher
+
+import "sync"
+
+// poolResult holds the outcome of a single unit of work done by the pool
+type poolResult[T any] struct {
+	index int
+	value T
+	err   error
+}
+
+// runOrdered runs work(i) for every i in [0, n) on up to `workers` goroutines and hands each result to
+// consume in index order, so the caller observes exactly what a sequential loop would. If consume returns
+// an error, the remaining work is abandoned and the error is returned
+func runOrdered[T any](n int, workers int, work func(i int) (T, error), consume func(i int, value T, err error) error) error {
+	if n == 0 {
+		return nil
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+
+	jobs := make(chan int)
+	results := make(chan poolResult[T])
+	done := make(chan struct{})
+	defer close(done)
+
+	// feeds the indexes to the workers
+	go func() {
+		defer close(jobs)
+		for i := 0; i < n; i++ {
+			select {
+			case jobs <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				value, err := work(i)
+				select {
+				case results <- poolResult[T]{index: i, value: value, err: err}:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// reorders the results so they are consumed in the same order as the input
+	pending := make(map[int]poolResult[T])
+	next := 0
+	for r := range results {
+		pending[r.index] = r
+		for {
+			res, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			if err := consume(res.index, res.value, res.err); err != nil {
+				return err
+			}
+			next++
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/c4ts0up/my-stocks/backend/models"
+	"gorm.io/gorm"
+	"log"
+)
+
+// ReplayResult is the outcome of replaying a quarantined row
+type ReplayResult struct {
+	ID     uint
+	Ticker string
+	// Replayed tells the row was converted and saved, leaving the quarantine. Otherwise Err tells why it wasn't
+	Replayed bool
+	Err      error
+}
+
+// ReplayQuarantined converts the quarantined rows with the given IDs again, or every quarantined row if no IDs are
+// given. The rows that convert now are saved like fetched ratings and leave the quarantine, all in a single
+// transaction. The others stay with their new error
+func (s *BasicStockRatingsFetcher) ReplayQuarantined(ctx context.Context, ids []uint) ([]ReplayResult, error) {
+	db := s.DB.WithContext(ctx)
+
+	query := db.Order("id")
+	if len(ids) > 0 {
+		query = query.Where("id IN ?", ids)
+	}
+	var rows []models.QuarantinedRating
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to load the quarantined rows: %w", err)
+	}
+
+	results := make([]ReplayResult, len(rows))
+	var ratings []models.StockRating
+	var replayed []uint
+	for i, row := range rows {
+		results[i] = ReplayResult{ID: row.ID, Ticker: row.Ticker}
+
+		rating, err := convertQuarantinedRating(row)
+		if err != nil {
+			results[i].Err = fmt.Errorf("failed to parse stock data, got %v", err)
+			continue
+		}
+		// the row is keyed like it would have been when fetched, by the source it was quarantined from
+		converted := []models.StockRating{rating}
+		keyBySource(row.Source, converted)
+		ratings = append(ratings, converted...)
+		replayed = append(replayed, row.ID)
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, result := range results {
+			if result.Err == nil {
+				continue
+			}
+			if err := tx.Model(&models.QuarantinedRating{}).Where("id = ?", result.ID).Update("error", result.Err.Error()).Error; err != nil {
+				return err
+			}
+		}
+		if len(replayed) == 0 {
+			return nil
+		}
+
+		writer := newRatingsWriter(tx)
+		if err := writer.write(ratings); err != nil {
+			return err
+		}
+		if err := writer.finish(); err != nil {
+			return err
+		}
+		return tx.Where("id IN ?", replayed).Delete(&models.QuarantinedRating{}).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save the replayed rows: %w", err)
+	}
+
+	for i := range results {
+		results[i].Replayed = results[i].Err == nil
+	}
+	log.Printf("Replayed %d of %d quarantined rows", len(replayed), len(rows))
+	return results, nil
+}
+
+// convertQuarantinedRating converts a quarantined row according to its format
+func convertQuarantinedRating(row models.QuarantinedRating) (models.StockRating, error) {
+	switch row.Format {
+	case models.RatingFormatApi:
+		var raw models.StockRatingRaw
+		if err := json.Unmarshal([]byte(row.RawJSON), &raw); err != nil {
+			return models.StockRating{}, err
+		}
+		return convertStockRatingsApiResponse(raw)
+	case models.RatingFormatStructured:
+		var raw models.StructuredRatingRaw
+		if err := json.Unmarshal([]byte(row.RawJSON), &raw); err != nil {
+			return models.StockRating{}, err
+		}
+		return convertStructuredRating(raw)
+	default:
+		return models.StockRating{}, fmt.Errorf("unknown row format %q", row.Format)
+	}
+}
@@ -0,0 +1,86 @@
+package fetcher
+
+import (
+	"context"
+	"github.com/c4ts0up/my-stocks/backend/models"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// --- TEST CASE 1: Malformed rows are quarantined as received, once, while the rest of the page is saved ---
+func TestFetchAllRatings_QuarantinesMalformedRows(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"items": [
+			{"ticker": "BSBR", "target_from": "$4.20", "target_to": "$4.70", "brokerage": "A", "time": "2025-01-13T00:30:05Z"},
+			{"ticker": "NA", "target_from": "N/A", "target_to": "$4.70", "brokerage": "A", "time": "2025-01-13T00:30:05Z"},
+			{"ticker": "TYPE", "target_from": 4.2, "target_to": "$4.70", "brokerage": "A", "time": "2025-01-13T00:30:05Z"}
+		], "next_page": ""}`))
+	}))
+	defer server.Close()
+
+	db := models.NewTestDB(nil)
+	fetcher := BasicStockRatingsFetcher{DB: db, BearerToken: mockTocken}
+
+	for run := 0; run < 2; run++ {
+		_, report, err := fetcher.FetchAllRatings(context.Background(), server.URL)
+		assert.NoError(t, err)
+		assert.Len(t, report.SkippedRatings, 2)
+	}
+
+	var rows []models.QuarantinedRating
+	db.Order("id").Find(&rows)
+	assert.Len(t, rows, 2)
+	assert.Equal(t, "NA", rows[0].Ticker)
+	assert.Equal(t, server.URL, rows[0].Source)
+	assert.Equal(t, 1, rows[0].Row)
+	assert.Equal(t, models.RatingFormatApi, rows[0].Format)
+	assert.Contains(t, rows[0].RawJSON, `"target_from":"N/A"`)
+	assert.Contains(t, rows[0].Error, "failed to parse stock data")
+	assert.False(t, rows[0].FetchedAt.IsZero())
+	assert.Equal(t, 2, rows[1].Row)
+	assert.Contains(t, rows[1].RawJSON, `"target_from":4.2`)
+
+	var count int64
+	db.Model(&models.StockRating{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+// --- TEST CASE 2: Replayed rows that convert are saved and leave the quarantine, the others keep their error ---
+func TestReplayQuarantined(t *testing.T) {
+	db := models.NewTestDB(nil)
+	fixed := `{"ticker": "AAPL", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "A", "time": "2025-01-13T00:30:05Z"}`
+	broken := `{"ticker": "MSFT", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "A", "time": "yesterday"}`
+	structured := `{"symbol": "GOOGL", "firm": "B", "published_at": "2025-01-13T00:30:05Z", "price_target": {"from": 1, "to": 2}}`
+	err := models.SaveQuarantinedRatings(context.Background(), db, []models.QuarantinedRating{
+		{Source: "api", Format: models.RatingFormatApi, Ticker: "AAPL", RawJSON: fixed, Error: "old"},
+		{Source: "api", Format: models.RatingFormatApi, Ticker: "MSFT", RawJSON: broken, Error: "old"},
+		{Source: "structured", Format: models.RatingFormatStructured, Ticker: "GOOGL", RawJSON: structured, Error: "old"},
+	})
+	assert.NoError(t, err)
+
+	fetcher := BasicStockRatingsFetcher{DB: db}
+	results, err := fetcher.ReplayQuarantined(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	assert.True(t, results[0].Replayed)
+	assert.False(t, results[1].Replayed)
+	assert.Error(t, results[1].Err)
+	assert.True(t, results[2].Replayed)
+
+	var remaining []models.QuarantinedRating
+	db.Find(&remaining)
+	assert.Len(t, remaining, 1)
+	assert.Equal(t, "MSFT", remaining[0].Ticker)
+	assert.Contains(t, remaining[0].Error, "failed to parse stock data")
+
+	var tickers []string
+	db.Model(&models.StockRating{}).Order("ticker").Pluck("ticker", &tickers)
+	assert.Equal(t, []string{"AAPL", "GOOGL"}, tickers)
+
+	// replaying selected rows leaves the others alone
+	results, err = fetcher.ReplayQuarantined(context.Background(), []uint{999})
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+}
@@ -0,0 +1,177 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is the rate allowed towards an upstream host. The zero value doesn't limit anything
+type RateLimit struct {
+	RequestsPerSecond float64
+	// Burst is the amount of requests that may be sent at once after a quiet period. Defaults to 1
+	Burst int
+}
+
+// RateLimiter throttles the outbound requests with a token bucket per upstream host. A single limiter is shared by
+// every fetcher, so requests to the same host are throttled together
+type RateLimiter struct {
+	mu           sync.Mutex
+	defaultLimit RateLimit
+	hostLimits   map[string]RateLimit
+	buckets      map[string]*tokenBucket
+}
+
+// NewRateLimiter creates a limiter applying hostLimits to their hosts and defaultLimit to every other host
+func NewRateLimiter(defaultLimit RateLimit, hostLimits map[string]RateLimit) *RateLimiter {
+	return &RateLimiter{
+		defaultLimit: defaultLimit,
+		hostLimits:   hostLimits,
+		buckets:      make(map[string]*tokenBucket),
+	}
+}
+
+// Wait blocks until a request to the host is allowed, returning how long it waited. If the context is done first,
+// the context's error is returned
+func (r *RateLimiter) Wait(ctx context.Context, host string) (time.Duration, error) {
+	r.mu.Lock()
+	bucket := r.bucket(host)
+	if bucket == nil {
+		r.mu.Unlock()
+		return 0, nil
+	}
+	wait := bucket.reserve(time.Now())
+	r.mu.Unlock()
+
+	if wait <= 0 {
+		return 0, nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return wait, nil
+	case <-ctx.Done():
+		// gives back the token that was never used
+		r.mu.Lock()
+		bucket.tokens++
+		r.mu.Unlock()
+		return 0, ctx.Err()
+	}
+}
+
+// bucket gets the bucket of a host, creating it on first use. Hosts without a limit get no bucket
+func (r *RateLimiter) bucket(host string) *tokenBucket {
+	if bucket, ok := r.buckets[host]; ok {
+		return bucket
+	}
+
+	limit, ok := r.hostLimits[host]
+	if !ok {
+		limit = r.defaultLimit
+	}
+	if limit.RequestsPerSecond <= 0 {
+		r.buckets[host] = nil
+		return nil
+	}
+
+	limit.Burst = max(limit.Burst, 1)
+	bucket := &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: time.Now()}
+	r.buckets[host] = bucket
+	return bucket
+}
+
+// Transport wraps a round tripper so every request waits for the rate limit of its host. A nil limiter doesn't
+// limit anything. The wait counts towards the timeout of the client using the transport
+func (r *RateLimiter) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if r == nil {
+		return base
+	}
+	return &rateLimitedTransport{limiter: r, base: base}
+}
+
+type rateLimitedTransport struct {
+	limiter *RateLimiter
+	base    http.RoundTripper
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	waited, err := t.limiter.Wait(req.Context(), req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	if waited > 0 {
+		log.Printf("Waited %v for the rate limit of %s", waited, req.URL.Host)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// tokenBucket holds up to Burst tokens, refilled at RequestsPerSecond. Each request takes a token
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token, returning how long to wait until it is actually available. Tokens may go negative, so
+// concurrent requests queue up one after the other
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.RequestsPerSecond)
+		b.last = now
+	}
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.limit.RequestsPerSecond * float64(time.Second))
+}
+
+// ParseRateLimit parses a rate limit given as "<requests per second>:<burst>", the burst being optional
+func ParseRateLimit(spec string) (RateLimit, error) {
+	rpsStr, burstStr, hasBurst := strings.Cut(strings.TrimSpace(spec), ":")
+
+	rps, err := strconv.ParseFloat(rpsStr, 64)
+	if err != nil || rps < 0 {
+		return RateLimit{}, fmt.Errorf("invalid requests per second in %q", spec)
+	}
+
+	limit := RateLimit{RequestsPerSecond: rps, Burst: 1}
+	if hasBurst {
+		if limit.Burst, err = strconv.Atoi(burstStr); err != nil || limit.Burst < 1 {
+			return RateLimit{}, fmt.Errorf("invalid burst in %q", spec)
+		}
+	}
+	return limit, nil
+}
+
+// ParseHostRateLimits parses comma separated "<host>=<requests per second>:<burst>" rate limits
+func ParseHostRateLimits(spec string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		host, limitSpec, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q, expected <host>=<requests per second>:<burst>", entry)
+		}
+		limit, err := ParseRateLimit(limitSpec)
+		if err != nil {
+			return nil, err
+		}
+		limits[strings.TrimSpace(host)] = limit
+	}
+	return limits, nil
+}
@@ -0,0 +1,96 @@
+package fetcher
+
+import (
+	"context"
+	"github.com/c4ts0up/my-stocks/backend/models"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// --- TEST CASE 1: Requests within the burst don't wait, the next ones wait for a token ---
+func TestRateLimiter_WaitsAfterBurst(t *testing.T) {
+	limiter := NewRateLimiter(RateLimit{}, map[string]RateLimit{"api.test": {RequestsPerSecond: 20, Burst: 2}})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		waited, err := limiter.Wait(ctx, "api.test")
+		assert.NoError(t, err)
+		assert.Zero(t, waited)
+	}
+
+	waited, err := limiter.Wait(ctx, "api.test")
+	assert.NoError(t, err)
+	assert.Greater(t, waited, 30*time.Millisecond)
+	assert.LessOrEqual(t, waited, 50*time.Millisecond)
+}
+
+// --- TEST CASE 2: Hosts are limited independently, and hosts without a limit are not limited ---
+func TestRateLimiter_PerHost(t *testing.T) {
+	limiter := NewRateLimiter(RateLimit{RequestsPerSecond: 1, Burst: 1}, map[string]RateLimit{"free.test": {}})
+	ctx := context.Background()
+
+	for _, host := range []string{"a.test", "b.test", "free.test", "free.test"} {
+		waited, err := limiter.Wait(ctx, host)
+		assert.NoError(t, err)
+		assert.Zero(t, waited, host)
+	}
+}
+
+// --- TEST CASE 3: Waiting stops when the context is cancelled, giving the token back ---
+func TestRateLimiter_ContextCancelled(t *testing.T) {
+	limiter := NewRateLimiter(RateLimit{RequestsPerSecond: 0.1, Burst: 1}, nil)
+	_, _ = limiter.Wait(context.Background(), "api.test")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := limiter.Wait(ctx, "api.test")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.InDelta(t, 0, limiter.buckets["api.test"].tokens, 0.01)
+}
+
+// --- TEST CASE 4: The fetchers wait on a shared limiter instead of firing every request at once ---
+func TestRateLimiter_SharedByFetchers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	limiter := NewRateLimiter(RateLimit{}, map[string]RateLimit{u.Host: {RequestsPerSecond: 50, Burst: 1}})
+
+	db := models.NewTestDB(nil)
+	infoFetcher := BasicStockInfoFetcher{DB: db, Limiter: limiter, Workers: 4}
+
+	start := time.Now()
+	report, err := infoFetcher.FetchAllInfo(context.Background(), []string{"AAPL", "MSFT", "GOOGL", "AMZN", "TSLA"}, server.URL)
+	assert.NoError(t, err)
+	assert.Len(t, report.SkippedTickers, 5)
+	// the first request goes through, each of the other four waits 20ms for a token
+	assert.GreaterOrEqual(t, time.Since(start), 75*time.Millisecond)
+}
+
+// --- TEST CASE 5: Rate limits are parsed from their configuration ---
+func TestParseHostRateLimits(t *testing.T) {
+	limits, err := ParseHostRateLimits("api.test=5:10, info.test=0.5")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]RateLimit{
+		"api.test":  {RequestsPerSecond: 5, Burst: 10},
+		"info.test": {RequestsPerSecond: 0.5, Burst: 1},
+	}, limits)
+
+	limits, err = ParseHostRateLimits("")
+	assert.NoError(t, err)
+	assert.Empty(t, limits)
+
+	_, err = ParseHostRateLimits("api.test")
+	assert.Error(t, err)
+	_, err = ParseHostRateLimits("api.test=fast")
+	assert.Error(t, err)
+	_, err = ParseRateLimit("5:0")
+	assert.Error(t, err)
+}
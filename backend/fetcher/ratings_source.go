@@ -0,0 +1,142 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"github.com/c4ts0up/my-stocks/backend/models"
+	"gorm.io/gorm"
+	"slices"
+	"sync"
+	"time"
+)
+
+// IRatingsSource is a source of stock ratings. Whatever the shape of its data, a source normalizes it into
+// StockRating models. Sources are paginated through an opaque cursor, the empty cursor being the first page
+type IRatingsSource interface {
+	FetchRatingsPage(ctx context.Context, cursor string) (StockRatingsPage, error)
+}
+
+// IStreamingRatingsSource is a ratings source able to hand the ratings of a page over in batches while the page is
+// being read, so large pages don't need to fit in memory. The returned page holds everything but the ratings
+type IStreamingRatingsSource interface {
+	IRatingsSource
+	StreamRatingsPage(ctx context.Context, cursor string, consume func(batch []models.StockRating) error) (StockRatingsPage, error)
+}
+
+// RatingsSource is a ratings source enabled under some name. The name identifies the source in the reports and
+// keys its sync state, so it must be unique and stable
+type RatingsSource struct {
+	Name   string
+	Source IRatingsSource
+}
+
+func (r RatingsSource) pageName(cursor string) string {
+	return fmt.Sprintf("%s page %q", r.Name, cursor)
+}
+
+// RatingsSourceConfig holds the settings a ratings source may be built with. Each kind of source uses the
+// settings it needs
+type RatingsSourceConfig struct {
+	URL   string
+	Token string
+	// Auth authenticates the requests of HTTP sources. Takes precedence over Token
+	Auth           IAuthenticator
+	Dir            string
+	Retry          RetryPolicy
+	RequestTimeout time.Duration
+	// Limiter throttles the requests of HTTP sources. Nil means no limit
+	Limiter *RateLimiter
+	// Breaker skips the requests of HTTP sources while their upstream is down. Nil means requests are always sent
+	Breaker *CircuitBreaker
+	// DB keeps the validators of the responses of HTTP sources making conditional requests
+	DB                  *gorm.DB
+	ConditionalRequests bool
+	// Fixtures records the traffic of HTTP sources, or replays it instead of calling them. Nil means neither
+	Fixtures *Fixtures
+}
+
+// RatingsSourceFactory builds a ratings source from its configuration
+type RatingsSourceFactory func(config RatingsSourceConfig) (IRatingsSource, error)
+
+var (
+	ratingsSourcesMu sync.RWMutex
+	ratingsSources   = map[string]RatingsSourceFactory{}
+)
+
+// RegisterRatingsSource makes a kind of ratings source available to NewRatingsSource. It panics if the kind is
+// registered twice
+func RegisterRatingsSource(kind string, factory RatingsSourceFactory) {
+	ratingsSourcesMu.Lock()
+	defer ratingsSourcesMu.Unlock()
+
+	if _, ok := ratingsSources[kind]; ok {
+		panic(fmt.Sprintf("ratings source %q registered twice", kind))
+	}
+	ratingsSources[kind] = factory
+}
+
+// NewRatingsSource builds a ratings source of the given kind
+func NewRatingsSource(kind string, config RatingsSourceConfig) (IRatingsSource, error) {
+	ratingsSourcesMu.RLock()
+	factory, ok := ratingsSources[kind]
+	ratingsSourcesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown ratings source %q, expected one of %v", kind, RatingsSourceKinds())
+	}
+	return factory(config)
+}
+
+// RatingsSourceKinds lists the registered kinds of ratings sources
+func RatingsSourceKinds() []string {
+	ratingsSourcesMu.RLock()
+	defer ratingsSourcesMu.RUnlock()
+
+	kinds := make([]string, 0, len(ratingsSources))
+	for kind := range ratingsSources {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+	return kinds
+}
+
+func init() {
+	RegisterRatingsSource("http", newApiRatingsSource)
+	RegisterRatingsSource("dropdir", newDropDirRatingsSource)
+	RegisterRatingsSource("structured", newStructuredRatingsSource)
+}
+
+// apiRatingsSource is the ratings API, paginated through its next_page parameter
+type apiRatingsSource struct {
+	fetcher *BasicStockRatingsFetcher
+	url     string
+}
+
+func newApiRatingsSource(config RatingsSourceConfig) (IRatingsSource, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("the http ratings source needs a URL")
+	}
+
+	return &apiRatingsSource{
+		fetcher: &BasicStockRatingsFetcher{
+			Auth:                config.Auth,
+			BearerToken:         config.Token,
+			Retry:               config.Retry,
+			RequestTimeout:      config.RequestTimeout,
+			Limiter:             config.Limiter,
+			Breaker:             config.Breaker,
+			DB:                  config.DB,
+			ConditionalRequests: config.ConditionalRequests && config.DB != nil,
+			Fixtures:            config.Fixtures,
+		},
+		url: config.URL,
+	}, nil
+}
+
+func (a *apiRatingsSource) FetchRatingsPage(ctx context.Context, cursor string) (StockRatingsPage, error) {
+	return a.fetcher.FetchStockRatings(ctx, a.url+"?next_page="+cursor)
+}
+
+func (a *apiRatingsSource) StreamRatingsPage(ctx context.Context, cursor string, consume func(batch []models.StockRating) error) (StockRatingsPage, error) {
+	return a.fetcher.StreamStockRatings(ctx, a.url+"?next_page="+cursor, consume)
+}
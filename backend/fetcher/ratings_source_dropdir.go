@@ -0,0 +1,161 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/c4ts0up/my-stocks/backend/models"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// dropDirRatingsSource reads ratings from the JSON and CSV files dropped in a local directory. Files are read in
+// name order, one file per page, and the cursor is the name of the file to read.
+// JSON files hold either an array of rows or an object with an "items" array, like the ratings API. CSV files have a
+// header row naming their columns after the fields of the ratings API
+type dropDirRatingsSource struct {
+	dir string
+}
+
+func newDropDirRatingsSource(config RatingsSourceConfig) (IRatingsSource, error) {
+	if config.Dir == "" {
+		return nil, fmt.Errorf("the dropdir ratings source needs a directory")
+	}
+	return &dropDirRatingsSource{dir: config.Dir}, nil
+}
+
+func (d *dropDirRatingsSource) FetchRatingsPage(ctx context.Context, cursor string) (StockRatingsPage, error) {
+	if err := ctx.Err(); err != nil {
+		return StockRatingsPage{}, err
+	}
+
+	files, err := d.files()
+	if err != nil {
+		return StockRatingsPage{}, err
+	}
+	if len(files) == 0 {
+		return StockRatingsPage{}, nil
+	}
+
+	// the empty cursor is the first file
+	index := 0
+	if cursor != "" {
+		var found bool
+		index, found = slices.BinarySearch(files, cursor)
+		if !found {
+			return StockRatingsPage{}, fmt.Errorf("file %s is no longer in %s", cursor, d.dir)
+		}
+	}
+
+	name := files[index]
+	rows, err := readRatingsFile(filepath.Join(d.dir, name))
+	if err != nil {
+		return StockRatingsPage{}, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	nextPage := ""
+	if index+1 < len(files) {
+		nextPage = files[index+1]
+	}
+	return convertStockRatingsPage(name, rows, nextPage), nil
+}
+
+// files lists the names of the ratings files in the directory, sorted
+func (d *dropDirRatingsSource) files() ([]string, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", d.dir, err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.Type().IsRegular() && (ext == ".json" || ext == ".csv") {
+			files = append(files, entry.Name())
+		}
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+// readRatingsFile reads the raw rows of a JSON or CSV ratings file
+func readRatingsFile(path string) ([]models.StockRatingRaw, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.ToLower(filepath.Ext(path)) == ".csv" {
+		return parseRatingsCsv(bytes.NewReader(content))
+	}
+	return parseRatingsJson(content)
+}
+
+// parseRatingsJson parses either an array of rows or a StockQueryResponse
+func parseRatingsJson(content []byte) ([]models.StockRatingRaw, error) {
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var rows []models.StockRatingRaw
+		if err := json.Unmarshal(trimmed, &rows); err != nil {
+			return nil, fmt.Errorf("failed to parse JSON: %w", err)
+		}
+		return rows, nil
+	}
+
+	var response models.StockQueryResponse
+	if err := json.Unmarshal(trimmed, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	return response.Stocks, nil
+}
+
+// parseRatingsCsv parses CSV rows whose header names the fields of StockRatingRaw, by their JSON names
+func parseRatingsCsv(r io.Reader) ([]models.StockRatingRaw, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CSV header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	var rows []models.StockRatingRaw
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CSV: %w", err)
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		rows = append(rows, models.StockRatingRaw{
+			ID:         field("id"),
+			Ticker:     field("ticker"),
+			TargetFrom: field("target_from"),
+			TargetTo:   field("target_to"),
+			Company:    field("company"),
+			Action:     field("action"),
+			Brokerage:  field("brokerage"),
+			RatingFrom: field("rating_from"),
+			RatingTo:   field("rating_to"),
+			Time:       field("time"),
+		})
+	}
+	return rows, nil
+}
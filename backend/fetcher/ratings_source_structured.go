@@ -0,0 +1,109 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/c4ts0up/my-stocks/backend/models"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// structuredPageSize is the amount of ratings requested to the structured ratings API per page
+const structuredPageSize = 100
+
+// structuredRatingsSource is a ratings API that gives structured ratings, with numeric targets, paginated by offset.
+// The cursor is the offset of the page
+type structuredRatingsSource struct {
+	url            string
+	auth           IAuthenticator
+	retry          RetryPolicy
+	requestTimeout time.Duration
+	limiter        *RateLimiter
+	breaker        *CircuitBreaker
+	fixtures       *Fixtures
+}
+
+func newStructuredRatingsSource(config RatingsSourceConfig) (IRatingsSource, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("the structured ratings source needs a URL")
+	}
+	auth := config.Auth
+	if auth == nil && config.Token != "" {
+		auth = &BearerAuth{Tokens: NewTokenRotation([]string{config.Token})}
+	}
+
+	return &structuredRatingsSource{
+		url:            config.URL,
+		auth:           auth,
+		retry:          config.Retry,
+		requestTimeout: config.RequestTimeout,
+		limiter:        config.Limiter,
+		breaker:        config.Breaker,
+		fixtures:       config.Fixtures,
+	}, nil
+}
+
+func (s *structuredRatingsSource) FetchRatingsPage(ctx context.Context, cursor string) (StockRatingsPage, error) {
+	offset := 0
+	if cursor != "" {
+		var err error
+		if offset, err = strconv.Atoi(cursor); err != nil {
+			return StockRatingsPage{}, fmt.Errorf("invalid cursor %q: %w", cursor, err)
+		}
+	}
+
+	u, err := url.Parse(s.url)
+	if err != nil {
+		return StockRatingsPage{}, fmt.Errorf("invalid base URL: %w", err)
+	}
+	q := u.Query()
+	q.Set("offset", strconv.Itoa(offset))
+	q.Set("limit", strconv.Itoa(structuredPageSize))
+	u.RawQuery = q.Encode()
+
+	log.Printf("Fetching structured ratings from %s", u.String())
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return StockRatingsPage{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := s.retry.Do(newHttpClient(s.requestTimeout, s.breaker, s.limiter, s.fixtures), req, s.auth)
+	if err != nil {
+		return StockRatingsPage{}, fmt.Errorf("failed to fetch data from %v: %w", u, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return StockRatingsPage{}, fmt.Errorf("received invalid response from API: %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return StockRatingsPage{}, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	var response models.StructuredRatingsResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return StockRatingsPage{}, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+
+	page := StockRatingsPage{}
+	for i, raw := range response.Data {
+		rating, err := convertStructuredRating(raw)
+		if err != nil {
+			page.reject(u.String(), i, raw.Symbol, models.RatingFormatStructured, raw, err)
+			continue
+		}
+		page.Ratings = append(page.Ratings, rating)
+	}
+
+	if next := offset + len(response.Data); len(response.Data) > 0 && next < response.Meta.Total {
+		page.NextPage = strconv.Itoa(next)
+	}
+	return page, nil
+}
@@ -0,0 +1,193 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"github.com/c4ts0up/my-stocks/backend/models"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// --- TEST CASE 1: The registry builds the built-in sources and rejects unknown ones ---
+func TestNewRatingsSource(t *testing.T) {
+	assert.Equal(t, []string{"dropdir", "http", "structured"}, RatingsSourceKinds())
+
+	_, err := NewRatingsSource("carrier-pigeon", RatingsSourceConfig{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown ratings source")
+
+	_, err = NewRatingsSource("http", RatingsSourceConfig{})
+	assert.Error(t, err)
+
+	source, err := NewRatingsSource("dropdir", RatingsSourceConfig{Dir: t.TempDir()})
+	assert.NoError(t, err)
+	assert.NotNil(t, source)
+
+	assert.Panics(t, func() { RegisterRatingsSource("http", newApiRatingsSource) })
+}
+
+// --- TEST CASE 2: The drop directory source reads one file per page ---
+func TestDropDirRatingsSource(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"01-ratings.json": `{"items": [
+			{"ticker": "BSBR", "target_from": "$4.20", "target_to": "$4.70", "brokerage": "A", "time": "2025-01-13T00:30:05Z"}
+		]}`,
+		"02-ratings.csv": "ticker,target_from,target_to,brokerage,rating_to,time\n" +
+			"VYGR,$11.00,$9.00,Wedbush,Outperform,2025-01-14T00:30:05Z\n" +
+			"AZEK,N/A,$9.00,Wedbush,Outperform,2025-01-14T00:30:05Z\n",
+		"03-ratings.json": `[{"ticker": "AAPL", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "B", "time": "2025-01-15T00:30:05Z"}]`,
+		"notes.txt":       "not a ratings file",
+	}
+	for name, content := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+
+	source, err := NewRatingsSource("dropdir", RatingsSourceConfig{Dir: dir})
+	assert.NoError(t, err)
+
+	page, err := source.FetchRatingsPage(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Len(t, page.Ratings, 1)
+	assert.Equal(t, "BSBR", page.Ratings[0].Ticker)
+	assert.Equal(t, "02-ratings.csv", page.NextPage)
+
+	page, err = source.FetchRatingsPage(context.Background(), page.NextPage)
+	assert.NoError(t, err)
+	assert.Len(t, page.Ratings, 1)
+	assert.Equal(t, "VYGR", page.Ratings[0].Ticker)
+	assert.Equal(t, "Outperform", page.Ratings[0].RatingTo)
+	assert.Equal(t, 9.0, page.Ratings[0].TargetTo)
+	assert.Len(t, page.Skipped, 1)
+	assert.Equal(t, "03-ratings.json", page.NextPage)
+
+	page, err = source.FetchRatingsPage(context.Background(), page.NextPage)
+	assert.NoError(t, err)
+	assert.Equal(t, "AAPL", page.Ratings[0].Ticker)
+	assert.Equal(t, "", page.NextPage)
+
+	_, err = source.FetchRatingsPage(context.Background(), "99-gone.json")
+	assert.Error(t, err)
+}
+
+// --- TEST CASE 3: The structured source normalizes its schema and pages by offset ---
+func TestStructuredRatingsSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer "+mockTocken, r.Header.Get("Authorization"))
+		offset := r.URL.Query().Get("offset")
+		symbol := map[string]string{"0": "AAPL", "1": "MSFT"}[offset]
+		_, _ = w.Write([]byte(fmt.Sprintf(`{
+			"data": [{
+				"id": "r-%s",
+				"symbol": "%s",
+				"firm": "Wedbush",
+				"action": "upgraded by",
+				"rating": {"from": "Neutral", "to": "Outperform"},
+				"price_target": {"from": 200, "to": 250.5},
+				"published_at": "2025-01-13T00:30:05Z"
+			}],
+			"meta": {"offset": %s, "total": 2}
+		}`, offset, symbol, offset)))
+	}))
+	defer server.Close()
+
+	source, err := NewRatingsSource("structured", RatingsSourceConfig{URL: server.URL, Token: mockTocken})
+	assert.NoError(t, err)
+
+	page, err := source.FetchRatingsPage(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Len(t, page.Ratings, 1)
+	rating := page.Ratings[0]
+	assert.Equal(t, "AAPL", rating.Ticker)
+	assert.Equal(t, "Wedbush", rating.Brokerage)
+	assert.Equal(t, "Neutral", rating.RatingFrom)
+	assert.Equal(t, "Outperform", rating.RatingTo)
+	assert.Equal(t, 200.0, rating.TargetFrom)
+	assert.Equal(t, 250.5, rating.TargetTo)
+	assert.Equal(t, "r-0", rating.UpstreamID)
+	assert.Equal(t, "1", page.NextPage)
+
+	page, err = source.FetchRatingsPage(context.Background(), page.NextPage)
+	assert.NoError(t, err)
+	assert.Equal(t, "MSFT", page.Ratings[0].Ticker)
+	assert.Equal(t, "", page.NextPage)
+}
+
+// --- TEST CASE 4: FetchAllRatings syncs every configured source ---
+func TestFetchAllRatings_MultipleSources(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "ratings.json"), []byte(`[
+		{"ticker": "AAPL", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "A", "time": "2025-01-13T00:30:05Z"}
+	]`), 0o644))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"items": [
+			{"ticker": "MSFT", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "B", "time": "2025-01-13T00:30:05Z"}
+		], "next_page": ""}`))
+	}))
+	defer server.Close()
+
+	dropDir, err := NewRatingsSource("dropdir", RatingsSourceConfig{Dir: dir})
+	assert.NoError(t, err)
+	api, err := NewRatingsSource("http", RatingsSourceConfig{URL: server.URL, Token: mockTocken})
+	assert.NoError(t, err)
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	_ = db.AutoMigrate(&models.Stock{}, &models.StockRating{}, &models.StockRatingRevision{}, &models.Brokerage{}, &models.BrokerageAlias{}, &models.RatingsSyncState{})
+
+	fetcher := BasicStockRatingsFetcher{DB: db, Sources: []RatingsSource{
+		{Name: "dropdir", Source: dropDir},
+		{Name: "http", Source: api},
+	}}
+
+	tickers, report, err := fetcher.FetchAllRatings(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AAPL", "MSFT"}, tickers)
+	assert.Len(t, report.SucceededPages, 2)
+
+	var states []models.RatingsSyncState
+	db.Order("source").Find(&states)
+	assert.Len(t, states, 2)
+	assert.Equal(t, "dropdir", states[0].Source)
+	assert.Equal(t, "http", states[1].Source)
+}
+
+// --- TEST CASE 5: Incremental syncs of the drop directory read the files dropped since the last run ---
+func TestFetchAllRatings_IncrementalDropDir(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "2025-01-13.json"), []byte(`[
+		{"ticker": "AAPL", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "A", "time": "2025-01-13T00:30:05Z"}
+	]`), 0o644))
+
+	source, err := NewRatingsSource("dropdir", RatingsSourceConfig{Dir: dir})
+	assert.NoError(t, err)
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	_ = db.AutoMigrate(&models.Stock{}, &models.StockRating{}, &models.StockRatingRevision{}, &models.Brokerage{}, &models.BrokerageAlias{}, &models.RatingsSyncState{})
+
+	fetcher := BasicStockRatingsFetcher{DB: db, Incremental: true, Sources: []RatingsSource{{Name: "dropdir", Source: source}}}
+	_, report, err := fetcher.FetchAllRatings(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`dropdir page ""`}, report.SucceededPages)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "2025-01-14.json"), []byte(`[
+		{"ticker": "MSFT", "target_from": "$1.00", "target_to": "$2.00", "brokerage": "A", "time": "2025-01-14T00:30:05Z"}
+	]`), 0o644))
+
+	_, report, err = fetcher.FetchAllRatings(context.Background(), "")
+	assert.NoError(t, err)
+	// the last file read is read again, and then the new one
+	assert.Equal(t, []string{`dropdir page ""`, `dropdir page "2025-01-14.json"`}, report.SucceededPages)
+
+	var count int64
+	db.Model(&models.StockRating{}).Where("ticker = ?", "MSFT").Count(&count)
+	assert.Equal(t, int64(1), count)
+}
@@ -0,0 +1,102 @@
+package fetcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ratingsStreamBatchSize is the amount of ratings handed over at once while streaming a page
+const ratingsStreamBatchSize = 500
+
+// decodeStockQueryStream decodes a StockQueryResponse one item at a time, handing the JSON of each row to onItem as
+// soon as it is read, so the items array is never held in memory. Returns the next page cursor, which may come after
+// the items
+func decodeStockQueryStream(r io.Reader, onItem func(raw json.RawMessage) error) (string, error) {
+	dec := json.NewDecoder(r)
+	var nextPage string
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return "", err
+	}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+
+		switch token {
+		case "items":
+			if err := decodeItems(dec, onItem); err != nil {
+				return "", err
+			}
+		case "next_page":
+			if err := dec.Decode(&nextPage); err != nil {
+				return "", err
+			}
+		default:
+			var ignored json.RawMessage
+			if err := dec.Decode(&ignored); err != nil {
+				return "", err
+			}
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return "", err
+	}
+
+	return nextPage, nil
+}
+
+// decodeItems decodes the items array, which may also be null
+func decodeItems(dec *json.Decoder, onItem func(raw json.RawMessage) error) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		return nil
+	}
+	if token != json.Delim('[') {
+		return fmt.Errorf("expected the items array, got %v", token)
+	}
+
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		if err := onItem(raw); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, ']')
+}
+
+// expectDelim reads the next token, failing unless it is the given delimiter
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %v, got %v", delim, token)
+	}
+	return nil
+}
+
+// readErrorReader remembers the error of the underlying reader, so failing to read the body can be told apart from
+// failing to decode it
+type readErrorReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *readErrorReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF {
+		e.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,97 @@
+package fetcher
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ItemError pairs a fetched item (a ticker, a page or a rating row) with the error that made it fail or be skipped
+type ItemError struct {
+	Item string
+	Err  error
+}
+
+func (e ItemError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Item, e.Err)
+}
+
+func (e ItemError) Unwrap() error {
+	return e.Err
+}
+
+// FetchReport summarizes the outcome of a fetch run. Failures of single items don't stop the run, they are
+// recorded here instead
+type FetchReport struct {
+	// SucceededPages lists the ratings pages fetched and saved
+	SucceededPages []string
+	// FailedPages lists the ratings pages that couldn't be fetched or saved
+	FailedPages []ItemError
+	// UnchangedPages lists the ratings pages left as they were, as they didn't change since they were last saved
+	UnchangedPages []string
+	// SavedRatings counts the ratings upserted from the saved pages
+	SavedRatings int
+	// SkippedRatings lists the malformed rating rows left out of their page
+	SkippedRatings []ItemError
+	// SucceededTickers lists the tickers whose info was fetched and saved
+	SucceededTickers []string
+	// UnchangedTickers lists the tickers whose info didn't change since it was last saved
+	UnchangedTickers []string
+	// SkippedTickers lists the tickers the info API returned no data for
+	SkippedTickers []ItemError
+	// FailedTickers lists the tickers whose info couldn't be fetched or saved
+	FailedTickers []ItemError
+}
+
+// Merge appends the contents of another report to this one
+func (r *FetchReport) Merge(other *FetchReport) {
+	if other == nil {
+		return
+	}
+	r.SucceededPages = append(r.SucceededPages, other.SucceededPages...)
+	r.FailedPages = append(r.FailedPages, other.FailedPages...)
+	r.UnchangedPages = append(r.UnchangedPages, other.UnchangedPages...)
+	r.SavedRatings += other.SavedRatings
+	r.SkippedRatings = append(r.SkippedRatings, other.SkippedRatings...)
+	r.SucceededTickers = append(r.SucceededTickers, other.SucceededTickers...)
+	r.UnchangedTickers = append(r.UnchangedTickers, other.UnchangedTickers...)
+	r.SkippedTickers = append(r.SkippedTickers, other.SkippedTickers...)
+	r.FailedTickers = append(r.FailedTickers, other.FailedTickers...)
+}
+
+// HasFailures tells if any page or ticker failed during the run
+func (r *FetchReport) HasFailures() bool {
+	return len(r.FailedPages) > 0 || len(r.FailedTickers) > 0
+}
+
+// Tickers counts the tickers whose info was processed, whatever the outcome
+func (r *FetchReport) Tickers() int {
+	return len(r.SucceededTickers) + len(r.UnchangedTickers) + len(r.SkippedTickers) + len(r.FailedTickers)
+}
+
+// Problems lists every failed or skipped item of the report
+func (r *FetchReport) Problems() []ItemError {
+	var problems []ItemError
+	problems = append(problems, r.FailedPages...)
+	problems = append(problems, r.SkippedRatings...)
+	problems = append(problems, r.FailedTickers...)
+	problems = append(problems, r.SkippedTickers...)
+	return problems
+}
+
+// String gives a one-line summary of the report
+func (r *FetchReport) String() string {
+	return fmt.Sprintf("pages: %d succeeded, %d unchanged, %d failed; ratings: %d saved, %d skipped; tickers: %d succeeded, %d unchanged, %d skipped, %d failed",
+		len(r.SucceededPages), len(r.UnchangedPages), len(r.FailedPages), r.SavedRatings, len(r.SkippedRatings),
+		len(r.SucceededTickers), len(r.UnchangedTickers), len(r.SkippedTickers), len(r.FailedTickers))
+}
+
+// countErrors counts the items that failed with the target error
+func countErrors(items []ItemError, target error) int {
+	count := 0
+	for _, item := range items {
+		if errors.Is(item, target) {
+			count++
+		}
+	}
+	return count
+}
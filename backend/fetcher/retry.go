@@ -0,0 +1,172 @@
+package fetcher
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy describes how failed upstream requests are retried. Only transient failures are retried: connection
+// resets, timeouts and the 408, 429, 502, 503 and 504 status codes. The zero value makes a single attempt
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry. It doubles on each following retry
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between attempts, including the one requested through Retry-After
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy used by the service unless configured otherwise
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// retryableStatusCodes are the status codes that signal a transient upstream problem
+var retryableStatusCodes = map[int]bool{
+	http.StatusRequestTimeout:     true,
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+// Do sends the request with the given client, retrying it according to the policy. The authenticator, if any, sets
+// the credentials before each attempt and is told about rate limits, so a retry may use other credentials.
+// When every attempt gets a retryable status code, the last response is returned so the caller can handle it as
+// usual. The wait between attempts is cut short when the request's context is done
+func (p RetryPolicy) Do(client *http.Client, req *http.Request, auth IAuthenticator) (*http.Response, error) {
+	attempts := max(p.MaxAttempts, 1)
+	ctx := req.Context()
+	if auth == nil {
+		auth = NoAuth{}
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := auth.Authenticate(req); err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		err = redactURLError(err)
+
+		// the caller gave up, so there is nothing to retry
+		if err != nil && ctx.Err() != nil {
+			return nil, err
+		}
+
+		var retryAfter time.Duration
+		var cause error
+		switch {
+		case err != nil:
+			if !isRetryableError(err) {
+				return nil, err
+			}
+			cause = err
+		case retryableStatusCodes[resp.StatusCode]:
+			if resp.StatusCode == http.StatusTooManyRequests {
+				auth.RateLimited(req)
+			}
+			retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			cause = fmt.Errorf("received %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+		default:
+			return resp, nil
+		}
+
+		if attempt >= attempts {
+			return resp, err
+		}
+
+		// discards the failed response so the connection can be reused
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		delay := p.delay(attempt, retryAfter)
+		// the query string is left out of the logs, as it may carry credentials
+		log.Printf("Retrying %s%s in %v (attempt %d/%d): %v", req.URL.Host, req.URL.Path, delay, attempt+1, attempts, cause)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// redactURLError leaves the query string and user info out of the URL of a failed request, as they may carry
+// credentials and the error ends up in the logs and the fetch reports
+func redactURLError(err error) error {
+	urlErr, ok := err.(*url.Error)
+	if !ok {
+		return err
+	}
+
+	redacted := *urlErr
+	redacted.URL = ""
+	if u, parseErr := url.Parse(urlErr.URL); parseErr == nil {
+		u.RawQuery = ""
+		u.User = nil
+		redacted.URL = u.String()
+	}
+	return &redacted
+}
+
+// delay computes the wait before the next attempt. Retry-After takes precedence over the exponential backoff,
+// which uses "equal jitter": half of the backoff is fixed and the other half is random
+func (p RetryPolicy) delay(attempt int, retryAfter time.Duration) time.Duration {
+	var delay time.Duration
+	if retryAfter > 0 {
+		delay = retryAfter
+	} else if p.BaseDelay > 0 {
+		backoff := p.BaseDelay << (attempt - 1)
+		if backoff <= 0 {
+			// overflowed, the cap below takes over
+			backoff = p.MaxDelay
+		}
+		half := backoff / 2
+		delay = half + rand.N(half+1)
+	}
+
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// isRetryableError tells if a transport error is transient, so repeating the request is safe
+func isRetryableError(err error) bool {
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// parseRetryAfter reads a Retry-After header, given either in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
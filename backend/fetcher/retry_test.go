@@ -0,0 +1,136 @@
+package fetcher
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+// --- TEST CASE 1: Transient status codes are retried until success ---
+func TestRetryPolicy_RetriesTransientStatus(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := testRetryPolicy.Do(http.DefaultClient, req, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+// --- TEST CASE 2: Non transient status codes are not retried ---
+func TestRetryPolicy_DoesNotRetryClientErrors(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := testRetryPolicy.Do(http.DefaultClient, req, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+// --- TEST CASE 3: The last response is returned once the attempts run out ---
+func TestRetryPolicy_GivesUp(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := testRetryPolicy.Do(http.DefaultClient, req, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+// --- TEST CASE 4: Retry-After is parsed and takes precedence over the backoff ---
+func TestRetryPolicy_RetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 13, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, 2*time.Second, parseRetryAfter("2", now))
+	assert.Equal(t, 5*time.Second, parseRetryAfter(now.Add(5*time.Second).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Minute}
+	assert.Equal(t, 2*time.Second, policy.delay(1, 2*time.Second))
+
+	policy.MaxDelay = time.Second
+	assert.Equal(t, time.Second, policy.delay(1, 2*time.Second))
+}
+
+// --- TEST CASE 5: The backoff grows exponentially and stays within its jitter range ---
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt, backoff := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond} {
+		delay := policy.delay(attempt, 0)
+		assert.GreaterOrEqual(t, delay, backoff/2)
+		assert.LessOrEqual(t, delay, backoff)
+	}
+	assert.LessOrEqual(t, policy.delay(10, 0), time.Second)
+}
+
+// --- TEST CASE 6: Both fetchers use their retry policy ---
+func TestRetryPolicy_Fetchers(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1)%2 == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Query().Has("tickers") {
+			_, _ = w.Write([]byte(`[{"ticker":"AAPL"}]`))
+		} else {
+			_, _ = w.Write([]byte(`{"items": [], "next_page": ""}`))
+		}
+	}))
+	defer server.Close()
+
+	ratingsFetcher := BasicStockRatingsFetcher{BearerToken: mockTocken, Retry: testRetryPolicy}
+	_, err := ratingsFetcher.FetchStockRatings(context.Background(), server.URL)
+	assert.NoError(t, err)
+
+	infoFetcher := BasicStockInfoFetcher{BearerToken: mockToken, Retry: testRetryPolicy}
+	stock, err := infoFetcher.FetchStockInfo(context.Background(), "AAPL", server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "AAPL", stock.Ticker)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
+
+// --- TEST CASE 7: Cancelling the context stops the wait between attempts ---
+func TestRetryPolicy_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		cancel()
+	}))
+	defer server.Close()
+
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Minute}
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+
+	start := time.Now()
+	_, err := policy.Do(http.DefaultClient, req, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), time.Second)
+}
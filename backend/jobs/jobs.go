@@ -0,0 +1,135 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/c4ts0up/my-stocks/backend/analyzer"
+	"github.com/c4ts0up/my-stocks/backend/fetcher"
+	"github.com/c4ts0up/my-stocks/backend/models"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+// StageAnalysis is the stage of the analysis jobs. Fetch jobs go through the stages of a fetch run
+const StageAnalysis = "analysis"
+
+// FetchResult is the result of a fetch job
+type FetchResult struct {
+	FetchRunID uint   `json:"fetch_run_id"`
+	Outcome    string `json:"outcome"`
+	Summary    string `json:"summary"`
+}
+
+// AnalysisResult is the result of an analysis job
+type AnalysisResult struct {
+	Analyzed int `json:"analyzed"`
+}
+
+// Service queues the fetch and analysis jobs, whether scheduled or triggered, on a shared runner so jobs of the same
+// kind never overlap
+type Service struct {
+	Runner     *Runner
+	DB         *gorm.DB
+	Fetcher    *fetcher.StockFetcher
+	Analyzer   analyzer.IAnalyzerPipeline
+	RatingsUrl string
+	InfoUrl    string
+	// FetchTimeout bounds each fetch job
+	FetchTimeout time.Duration
+}
+
+// QueueFetch queues a fetch job. Without tickers, the ratings and then the info of every rated ticker are fetched.
+// With tickers, only their info is
+func (s *Service) QueueFetch(trigger string, tickers []string) Job {
+	return s.Runner.Submit(KindFetch, trigger, tickers, s.fetchJob(tickers))
+}
+
+// QueueAnalysis queues an analysis job, of every stock or only of the given ticker
+func (s *Service) QueueAnalysis(trigger string, ticker string) Job {
+	var tickers []string
+	if ticker != "" {
+		tickers = []string{ticker}
+	}
+	return s.Runner.Submit(KindAnalysis, trigger, tickers, s.analysisJob(ticker))
+}
+
+// ScheduleFetch queues a fetch of everything, unless a fetch is already pending
+func (s *Service) ScheduleFetch() (Job, bool) {
+	return s.Runner.SubmitUnlessPending(KindFetch, TriggerScheduled, s.fetchJob(nil))
+}
+
+// ScheduleAnalysis queues an analysis of every stock, unless an analysis is already pending
+func (s *Service) ScheduleAnalysis() (Job, bool) {
+	return s.Runner.SubmitUnlessPending(KindAnalysis, TriggerScheduled, s.analysisJob(""))
+}
+
+// fetchJob fetches the data of the given tickers, or of everything, recording the run in the fetch runs
+func (s *Service) fetchJob(tickers []string) Func {
+	return func(ctx context.Context, progress Progress) (any, error) {
+		log.Println("🔄 Fetching data...")
+		run, report, err := fetcher.RecordFetchRun(ctx, s.DB, func(ctx context.Context) (*fetcher.FetchReport, error) {
+			runCtx, cancel := context.WithTimeout(ctx, s.FetchTimeout)
+			defer cancel()
+
+			if len(tickers) > 0 {
+				progress(fetcher.StageInfo, 0, len(tickers))
+				return s.Fetcher.InfoFetcher.FetchAllInfo(runCtx, tickers, s.InfoUrl)
+			}
+			return s.Fetcher.FetchAllStages(runCtx, s.RatingsUrl, s.InfoUrl, func(stage string, items int) {
+				progress(stage, 0, items)
+			})
+		})
+		if tickers := report.Tickers(); tickers > 0 {
+			progress(fetcher.StageInfo, tickers, tickers)
+		}
+
+		for _, problem := range report.Problems() {
+			// skips are already summed up by the fetchers while an upstream is down
+			if errors.Is(problem, fetcher.ErrCircuitOpen) {
+				continue
+			}
+			log.Printf("⚠️ %v", problem)
+		}
+		if err != nil {
+			log.Printf("❌ Data fetch %d incomplete: %v (%s)", run.ID, err, report)
+		} else {
+			log.Printf("✅ Data fetch %d done (%s)", run.ID, report)
+		}
+
+		return FetchResult{FetchRunID: run.ID, Outcome: run.Outcome, Summary: report.String()}, err
+	}
+}
+
+// analysisJob analyzes the given ticker, or every stock
+func (s *Service) analysisJob(ticker string) Func {
+	return func(ctx context.Context, progress Progress) (any, error) {
+		log.Println("🔄 Getting all stocks ...")
+		var stocks []models.Stock
+		query := s.DB.WithContext(ctx)
+		if ticker != "" {
+			query = query.Where("ticker = ?", ticker)
+		}
+		if err := query.Find(&stocks).Error; err != nil {
+			return nil, fmt.Errorf("couldn't fetch the stocks: %w", err)
+		}
+		if ticker != "" && len(stocks) == 0 {
+			return nil, fmt.Errorf("stock %s not found", ticker)
+		}
+
+		result := AnalysisResult{}
+		for i := range stocks {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			progress(StageAnalysis, i, len(stocks))
+			s.Analyzer.Analyze(&stocks[i])
+			result.Analyzed++
+		}
+		progress(StageAnalysis, len(stocks), len(stocks))
+
+		log.Printf("✅ Analyzed %d stocks", result.Analyzed)
+		return result, nil
+	}
+}
@@ -0,0 +1,221 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Kinds of jobs. Jobs of the same kind run one at a time, in the order they were queued
+const (
+	KindFetch    = "fetch"
+	KindAnalysis = "analysis"
+)
+
+// What triggered a job
+const (
+	TriggerScheduled = "scheduled"
+	TriggerManual    = "manual"
+	// TriggerIngest is the trigger of the info refreshes of the tickers that got pushed ratings
+	TriggerIngest = "ingest"
+)
+
+// Statuses of a job
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// maxRetainedJobs bounds the finished jobs kept for their status to be queried
+const maxRetainedJobs = 200
+
+// Job is the status of a queued job, from its queueing to its result
+type Job struct {
+	ID      string
+	Kind    string
+	Trigger string
+	// Tickers limits the job to some tickers. Empty when it covers every ticker
+	Tickers []string
+	Status  string
+	// Stage, Done and Total report the progress of a running job
+	Stage string
+	Done  int
+	Total int
+	// Result is set by the job once it is done. Error is set when it failed
+	Result     any
+	Error      string
+	QueuedAt   time.Time
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+}
+
+// Pending tells whether the job is yet to finish
+func (j Job) Pending() bool {
+	return j.Status == StatusQueued || j.Status == StatusRunning
+}
+
+// Progress reports the progress of a running job, as done out of total items of its current stage
+type Progress func(stage string, done int, total int)
+
+// Func runs a job, returning its result
+type Func func(ctx context.Context, progress Progress) (any, error)
+
+// Runner runs the queued jobs in the background, one at a time for each kind, and keeps their status
+type Runner struct {
+	ctx context.Context
+
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	order   []string
+	queues  map[string][]queuedJob
+	running map[string]bool
+}
+
+type queuedJob struct {
+	job *Job
+	run Func
+}
+
+// NewRunner creates a runner whose jobs run until the context is done
+func NewRunner(ctx context.Context) *Runner {
+	return &Runner{
+		ctx:     ctx,
+		jobs:    make(map[string]*Job),
+		queues:  make(map[string][]queuedJob),
+		running: make(map[string]bool),
+	}
+}
+
+// Submit queues a job, to run once the jobs of the same kind queued before it are done
+func (r *Runner) Submit(kind string, trigger string, tickers []string, run Func) Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.submit(kind, trigger, tickers, run)
+}
+
+// SubmitUnlessPending queues a job unless a job of the same kind is already queued or running, as scheduled jobs
+// shouldn't pile up behind a slow one. Tells whether the job was queued
+func (r *Runner) SubmitUnlessPending(kind string, trigger string, run Func) (Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running[kind] {
+		return Job{}, false
+	}
+	return r.submit(kind, trigger, nil, run), true
+}
+
+func (r *Runner) submit(kind string, trigger string, tickers []string, run Func) Job {
+	job := &Job{
+		ID:       newJobID(),
+		Kind:     kind,
+		Trigger:  trigger,
+		Tickers:  tickers,
+		Status:   StatusQueued,
+		QueuedAt: time.Now(),
+	}
+	r.jobs[job.ID] = job
+	r.order = append(r.order, job.ID)
+	r.queues[kind] = append(r.queues[kind], queuedJob{job: job, run: run})
+	r.evict()
+
+	if !r.running[kind] {
+		r.running[kind] = true
+		go r.drain(kind)
+	}
+	return *job
+}
+
+// Get gets the status of a job
+func (r *Runner) Get(id string) (Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// drain runs the queued jobs of a kind until none is left
+func (r *Runner) drain(kind string) {
+	for {
+		r.mu.Lock()
+		queue := r.queues[kind]
+		if len(queue) == 0 {
+			r.running[kind] = false
+			r.mu.Unlock()
+			return
+		}
+		next := queue[0]
+		r.queues[kind] = queue[1:]
+		startedAt := time.Now()
+		next.job.Status = StatusRunning
+		next.job.StartedAt = &startedAt
+		r.mu.Unlock()
+
+		result, err := r.run(next)
+
+		r.mu.Lock()
+		finishedAt := time.Now()
+		next.job.FinishedAt = &finishedAt
+		next.job.Result = result
+		if err != nil {
+			next.job.Status = StatusFailed
+			next.job.Error = err.Error()
+		} else {
+			next.job.Status = StatusSucceeded
+		}
+		r.mu.Unlock()
+	}
+}
+
+// run runs a job, turning a panic into its error so the queue keeps going
+func (r *Runner) run(next queuedJob) (result any, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Printf("Job %s (%s) panicked: %v", next.job.ID, next.job.Kind, recovered)
+			err = fmt.Errorf("job panicked: %v", recovered)
+		}
+	}()
+
+	if err := r.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return next.run(r.ctx, func(stage string, done int, total int) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		next.job.Stage, next.job.Done, next.job.Total = stage, done, total
+	})
+}
+
+// evict forgets the oldest finished jobs beyond maxRetainedJobs. Pending jobs are always kept
+func (r *Runner) evict() {
+	excess := len(r.order) - maxRetainedJobs
+	if excess <= 0 {
+		return
+	}
+
+	kept := r.order[:0]
+	for _, id := range r.order {
+		if excess > 0 && !r.jobs[id].Pending() {
+			delete(r.jobs, id)
+			excess--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	r.order = kept
+}
+
+// newJobID generates a random job ID
+func newJobID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,166 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"github.com/c4ts0up/my-stocks/backend/analyzer"
+	"github.com/c4ts0up/my-stocks/backend/fetcher"
+	"github.com/c4ts0up/my-stocks/backend/models"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForJob waits until the job is done, failing the test if it takes too long
+func waitForJob(t *testing.T, runner *Runner, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, ok := runner.Get(id); ok && !job.Pending() {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s didn't finish in time", id)
+	return Job{}
+}
+
+// --- TEST CASE 1: Jobs of the same kind run one at a time, in the order they were queued ---
+func TestRunner_NoOverlap(t *testing.T) {
+	runner := NewRunner(context.Background())
+
+	var running, maxRunning int32
+	var order []int
+	job := func(n int) Func {
+		return func(ctx context.Context, progress Progress) (any, error) {
+			current := atomic.AddInt32(&running, 1)
+			if current > atomic.LoadInt32(&maxRunning) {
+				atomic.StoreInt32(&maxRunning, current)
+			}
+			time.Sleep(10 * time.Millisecond)
+			order = append(order, n)
+			atomic.AddInt32(&running, -1)
+			return n, nil
+		}
+	}
+
+	var ids []string
+	for n := 0; n < 3; n++ {
+		ids = append(ids, runner.Submit(KindFetch, TriggerManual, nil, job(n)).ID)
+	}
+	for n, id := range ids {
+		finished := waitForJob(t, runner, id)
+		assert.Equal(t, StatusSucceeded, finished.Status)
+		assert.Equal(t, n, finished.Result)
+	}
+
+	assert.Equal(t, int32(1), maxRunning)
+	assert.Equal(t, []int{0, 1, 2}, order)
+}
+
+// --- TEST CASE 2: Scheduled jobs are skipped while a job of the same kind is pending ---
+func TestRunner_SubmitUnlessPending(t *testing.T) {
+	runner := NewRunner(context.Background())
+
+	release := make(chan struct{})
+	blocking := runner.Submit(KindFetch, TriggerManual, []string{"AAPL"}, func(ctx context.Context, progress Progress) (any, error) {
+		progress("info", 0, 1)
+		<-release
+		return nil, errors.New("boom")
+	})
+
+	_, queued := runner.SubmitUnlessPending(KindFetch, TriggerScheduled, func(ctx context.Context, progress Progress) (any, error) {
+		return nil, nil
+	})
+	assert.False(t, queued)
+
+	// other kinds aren't held back
+	analysis, queued := runner.SubmitUnlessPending(KindAnalysis, TriggerScheduled, func(ctx context.Context, progress Progress) (any, error) {
+		return nil, nil
+	})
+	assert.True(t, queued)
+	assert.Equal(t, StatusSucceeded, waitForJob(t, runner, analysis.ID).Status)
+
+	close(release)
+	finished := waitForJob(t, runner, blocking.ID)
+	assert.Equal(t, StatusFailed, finished.Status)
+	assert.Equal(t, "boom", finished.Error)
+	assert.Equal(t, "info", finished.Stage)
+	assert.NotNil(t, finished.FinishedAt)
+
+	_, queued = runner.SubmitUnlessPending(KindFetch, TriggerScheduled, func(ctx context.Context, progress Progress) (any, error) {
+		return nil, nil
+	})
+	assert.True(t, queued)
+}
+
+// --- TEST CASE 3: An analysis job of a ticker reports its progress and result ---
+func TestService_QueueAnalysis(t *testing.T) {
+	db := models.NewTestDB(nil)
+	db.Create(&models.Stock{Ticker: "AAPL"})
+	db.Create(&models.Stock{Ticker: "MSFT"})
+
+	var analyzed []string
+	service := &Service{
+		Runner:   NewRunner(context.Background()),
+		DB:       db,
+		Analyzer: analyzerFunc(func(stock *models.Stock) { analyzed = append(analyzed, stock.Ticker) }),
+	}
+
+	job := waitForJob(t, service.Runner, service.QueueAnalysis(TriggerManual, "MSFT").ID)
+	assert.Equal(t, StatusSucceeded, job.Status)
+	assert.Equal(t, []string{"MSFT"}, job.Tickers)
+	assert.Equal(t, AnalysisResult{Analyzed: 1}, job.Result)
+	assert.Equal(t, StageAnalysis, job.Stage)
+	assert.Equal(t, 1, job.Done)
+	assert.Equal(t, []string{"MSFT"}, analyzed)
+
+	job = waitForJob(t, service.Runner, service.QueueAnalysis(TriggerManual, "").ID)
+	assert.Equal(t, AnalysisResult{Analyzed: 2}, job.Result)
+}
+
+// --- TEST CASE 4: The fetch and analysis jobs run offline on the replayed upstream traffic ---
+func TestService_ReplayedFixtures(t *testing.T) {
+	db := models.NewTestDB(nil)
+	models.DB = db
+	fixtures, err := fetcher.NewFixtures(fetcher.FixturesReplay, "testdata/fixtures")
+	assert.NoError(t, err)
+
+	service := &Service{
+		Runner: NewRunner(context.Background()),
+		DB:     db,
+		Fetcher: &fetcher.StockFetcher{
+			RatingsFetcher: &fetcher.BasicStockRatingsFetcher{DB: db, Auth: fetcher.NoAuth{}, Fixtures: fixtures},
+			InfoFetcher:    &fetcher.BasicStockInfoFetcher{DB: db, Fixtures: fixtures},
+		},
+		Analyzer:     &analyzer.BasicAnalyzerPipeline{},
+		RatingsUrl:   "https://ratings.example.com/ratings",
+		InfoUrl:      "https://info.example.com/stocks",
+		FetchTimeout: 5 * time.Second,
+	}
+
+	job := waitForJob(t, service.Runner, service.QueueFetch(TriggerManual, nil).ID)
+	assert.Equal(t, StatusSucceeded, job.Status)
+	assert.Equal(t, models.FetchRunSucceeded, job.Result.(FetchResult).Outcome)
+	job = waitForJob(t, service.Runner, service.QueueAnalysis(TriggerManual, "").ID)
+	assert.Equal(t, AnalysisResult{Analyzed: 2}, job.Result)
+
+	var stocks []models.Stock
+	db.Order("ticker").Find(&stocks)
+	assert.Len(t, stocks, 2)
+	assert.Equal(t, "Apple Inc.", stocks[0].Company)
+	assert.Equal(t, 214.65, stocks[0].LastPrice)
+	assert.NotEmpty(t, stocks[0].Recommendation)
+	assert.Equal(t, "Microsoft Corporation", stocks[1].Company)
+
+	var ratings int64
+	db.Model(&models.StockRating{}).Count(&ratings)
+	assert.Equal(t, int64(3), ratings)
+}
+
+type analyzerFunc func(stock *models.Stock)
+
+func (f analyzerFunc) Analyze(stock *models.Stock) {
+	f(stock)
+}
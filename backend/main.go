@@ -1,54 +1,187 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"github.com/c4ts0up/my-stocks/backend/analyzer"
 	"github.com/c4ts0up/my-stocks/backend/fetcher"
+	"github.com/c4ts0up/my-stocks/backend/jobs"
 	"github.com/c4ts0up/my-stocks/backend/models"
 	"github.com/c4ts0up/my-stocks/backend/presenter"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
-// Periodic fetcher (runs in the background)
-func startPeriodicFetchAll(interval int, ratingsUrl string, infoUrl string, api *fetcher.StockFetcher) {
+// Periodic fetcher (runs in the background). Each tick queues a fetch, unless one is already pending, and the loop
+// stops once ctx is done
+func startPeriodicFetchAll(ctx context.Context, interval int, service *jobs.Service) {
 	intervalDuration := time.Duration(interval) * time.Second
 	ticker := time.NewTicker(intervalDuration)
 	defer ticker.Stop()
 
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-ticker.C:
-			log.Println("🔄 Fetching data...")
-			_ = api.FetchAll(ratingsUrl, infoUrl)
-			log.Printf("✅ Data fetched")
+			if _, queued := service.ScheduleFetch(); !queued {
+				log.Println("Skipping the scheduled fetch, a fetch is already pending")
+			}
 		}
 	}
 }
 
-func startPeriodicAnalysis(interval int, analyzerPipeline analyzer.IAnalyzerPipeline) {
+// Periodic analysis (runs in the background). Each tick queues an analysis, unless one is already pending
+func startPeriodicAnalysis(ctx context.Context, interval int, service *jobs.Service) {
 	intervalDuration := time.Duration(interval) * time.Second
 	ticker := time.NewTicker(intervalDuration)
 	defer ticker.Stop()
 
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-ticker.C:
-			log.Println("🔄 Getting all stocks ...")
-			stocks, err := models.GetAllStocks()
-			if err != nil {
-				log.Printf("Couldn't fetch all stocks, %e\n", err)
+			if _, queued := service.ScheduleAnalysis(); !queued {
+				log.Println("Skipping the scheduled analysis, an analysis is already pending")
 			}
+		}
+	}
+}
 
-			for _, stock := range stocks {
-				analyzerPipeline.Analyze(&stock)
-			}
-			log.Printf("✅ Data fetched")
+// getOptionalIntEnv parses an optional integer environment variable, returning the fallback when it is not set
+func getOptionalIntEnv(name string, fallback int) int {
+	valueStr := os.Getenv(name)
+	if valueStr == "" {
+		return fallback
+	}
+	value, err := strconv.Atoi(valueStr)
+	if err != nil {
+		log.Fatalf("Could not parse the %s environment variable: %v", name, err)
+	}
+	return value
+}
+
+// buildAuthenticator builds the authenticator of an upstream from the environment variables starting with prefix:
+// <prefix>_AUTH chooses the auth type, <prefix>_TOKEN holds one or more comma separated tokens, <prefix>_AUTH_HEADER
+// and <prefix>_AUTH_PARAM name where API keys go, and <prefix>_USERNAME and <prefix>_PASSWORD are used by basic auth.
+// Without <prefix>_AUTH, a bearer token is sent if there is one
+func buildAuthenticator(prefix string, fixtures *fetcher.Fixtures) fetcher.IAuthenticator {
+	config := fetcher.AuthConfig{
+		Type:     os.Getenv(prefix + "_AUTH"),
+		Tokens:   strings.Split(os.Getenv(prefix+"_TOKEN"), ","),
+		Header:   os.Getenv(prefix + "_AUTH_HEADER"),
+		Param:    os.Getenv(prefix + "_AUTH_PARAM"),
+		Username: os.Getenv(prefix + "_USERNAME"),
+		Password: os.Getenv(prefix + "_PASSWORD"),
+	}
+	if config.Type == "" && os.Getenv(prefix+"_TOKEN") != "" {
+		config.Type = "bearer"
+	}
+
+	auth, err := fetcher.NewAuthenticator(config)
+	if err != nil {
+		log.Fatalf("Could not set up the authentication of %s: %v", prefix, err)
+	}
+	// the credentials sent in the query are kept out of the recorded fixtures
+	fixtures.IgnoreCredentials(auth)
+	return auth
+}
+
+// buildRateLimiter builds the rate limiter shared by every fetcher. RATE_LIMITS holds comma separated
+// <host>=<requests per second>:<burst> limits, and RATE_LIMIT_DEFAULT the <requests per second>:<burst> limit of any
+// other host. Hosts without a limit are not throttled
+func buildRateLimiter() *fetcher.RateLimiter {
+	var defaultLimit fetcher.RateLimit
+	if spec := os.Getenv("RATE_LIMIT_DEFAULT"); spec != "" {
+		var err error
+		if defaultLimit, err = fetcher.ParseRateLimit(spec); err != nil {
+			log.Fatalf("Could not parse the RATE_LIMIT_DEFAULT environment variable: %v", err)
+		}
+	}
+
+	hostLimits, err := fetcher.ParseHostRateLimits(os.Getenv("RATE_LIMITS"))
+	if err != nil {
+		log.Fatalf("Could not parse the RATE_LIMITS environment variable: %v", err)
+	}
+
+	return fetcher.NewRateLimiter(defaultLimit, hostLimits)
+}
+
+// buildRatingsSources builds the ratings sources enabled in RATINGS_PROVIDERS, a comma separated list of source
+// kinds, on top of the settings in base. The http source is configured through RATINGS_API_URL and the RATINGS_API
+// auth variables, while any other kind reads RATINGS_<KIND>_URL, RATINGS_<KIND>_DIR and the RATINGS_<KIND> auth
+// variables. Each source calling an upstream gets its own circuit breaker from newBreaker
+func buildRatingsSources(base fetcher.RatingsSourceConfig, newBreaker func(name string) *fetcher.CircuitBreaker) []fetcher.RatingsSource {
+	providers := os.Getenv("RATINGS_PROVIDERS")
+	if providers == "" {
+		providers = "http"
+	}
+
+	var sources []fetcher.RatingsSource
+	for _, kind := range strings.Split(providers, ",") {
+		kind = strings.TrimSpace(kind)
+		config := base
+		name := kind
+		if kind == "http" {
+			config.URL = os.Getenv("RATINGS_API_URL")
+			config.Auth = buildAuthenticator("RATINGS_API", base.Fixtures)
+			// keeps the sync state of the ratings API, which is keyed by its URL
+			name = config.URL
+		} else {
+			prefix := "RATINGS_" + strings.ToUpper(kind)
+			config.URL = os.Getenv(prefix + "_URL")
+			config.Dir = os.Getenv(prefix + "_DIR")
+			config.Auth = buildAuthenticator(prefix, base.Fixtures)
+		}
+		if config.URL != "" {
+			config.Breaker = newBreaker("ratings " + kind)
 		}
+
+		source, err := fetcher.NewRatingsSource(kind, config)
+		if err != nil {
+			log.Fatalf("Could not set up the %s ratings provider: %v", kind, err)
+		}
+		sources = append(sources, fetcher.RatingsSource{Name: name, Source: source})
+	}
+
+	return sources
+}
+
+// checkConsistency runs the check-consistency command. It reports the ratings and prices of tickers without a stock
+// and, with -repair, creates placeholder stocks for them. Exits with 1 while orphans remain
+func checkConsistency(args []string) int {
+	flags := flag.NewFlagSet("check-consistency", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "create placeholder stocks for the orphans")
+	_ = flags.Parse(args)
+
+	report, err := models.CheckConsistency(context.Background(), models.DB, *repair)
+	if err != nil {
+		log.Printf("Failed to check the consistency: %v", err)
+		return 2
 	}
+	if report.Consistent() {
+		log.Println("✅ Every rating and price references a stock")
+		return 0
+	}
+
+	log.Printf("Found %d current ratings, %d revisions and %d price snapshots without a stock, for %s",
+		report.OrphanRatings, report.OrphanRevisions, report.OrphanSnapshots, strings.Join(report.OrphanTickers, ", "))
+	if !report.Repaired {
+		log.Println("Run with -repair to create placeholder stocks for them")
+		return 1
+	}
+	log.Printf("Created %d placeholder stocks, the next fetch fills them in", len(report.OrphanTickers))
+	return 0
 }
 
 func main() {
@@ -60,9 +193,7 @@ func main() {
 	analysisDelayStr := os.Getenv("ANALYSIS_DELAY_S")
 
 	ratingsApiUrl := os.Getenv("RATINGS_API_URL")
-	ratingsApiToken := os.Getenv("RATINGS_API_TOKEN")
 	infoApiUrl := os.Getenv("INFO_API_URL")
-	infoApiToken := os.Getenv("INFO_API_TOKEN")
 	maxDbConnectionRetriesStr := os.Getenv("MAX_DB_CONNECTION_RETRIES")
 	dbConnectionRetryDelayStr := os.Getenv("DB_CONNECTION_RETRY_DELAY_S")
 
@@ -84,6 +215,41 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to convert FETCH_DELAY to int: %v", err)
 	}
+	infoFetchWorkers := getOptionalIntEnv("INFO_FETCH_WORKERS", fetcher.DefaultInfoWorkers)
+	infoBatchSize := getOptionalIntEnv("INFO_BATCH_SIZE", 1)
+	// share classes are sent to the info API with this separator, such as "-" for BRK-B
+	infoTickerSeparator := os.Getenv("INFO_TICKER_SEPARATOR")
+	retryPolicy := fetcher.DefaultRetryPolicy
+	retryPolicy.MaxAttempts = getOptionalIntEnv("FETCH_MAX_ATTEMPTS", retryPolicy.MaxAttempts)
+	retryPolicy.BaseDelay = time.Duration(getOptionalIntEnv("FETCH_RETRY_BASE_DELAY_MS", int(retryPolicy.BaseDelay/time.Millisecond))) * time.Millisecond
+	retryPolicy.MaxDelay = time.Duration(getOptionalIntEnv("FETCH_RETRY_MAX_DELAY_MS", int(retryPolicy.MaxDelay/time.Millisecond))) * time.Millisecond
+	fetchTimeout := time.Duration(getOptionalIntEnv("FETCH_TIMEOUT_S", fetchDelaySeconds)) * time.Second
+	requestTimeout := time.Duration(getOptionalIntEnv("FETCH_REQUEST_TIMEOUT_S", 30)) * time.Second
+	rateLimiter := buildRateLimiter()
+	// Unchanged upstream responses are not downloaded again unless conditional requests are turned off
+	conditionalRequests := os.Getenv("FETCH_CONDITIONAL_REQUESTS") != "false"
+	circuitFailureThreshold := getOptionalIntEnv("CIRCUIT_FAILURE_THRESHOLD", fetcher.DefaultFailureThreshold)
+	circuitOpenTimeout := time.Duration(getOptionalIntEnv("CIRCUIT_OPEN_TIMEOUT_S", int(fetcher.DefaultOpenTimeout/time.Second))) * time.Second
+	// every breaker is reported by the API, so degraded upstreams are visible
+	newBreaker := func(name string) *fetcher.CircuitBreaker {
+		breaker := fetcher.NewCircuitBreaker(name, circuitFailureThreshold, circuitOpenTimeout)
+		presenter.Upstreams = append(presenter.Upstreams, breaker)
+		return breaker
+	}
+	// Ratings are synced incrementally unless a full sync on every run is explicitly requested
+	incrementalRatings := os.Getenv("RATINGS_SYNC_MODE") != "full"
+	// Upstream traffic is recorded to the fixtures, or replayed from them so the service runs offline
+	fixturesDir := os.Getenv("UPSTREAM_FIXTURES_DIR")
+	if fixturesDir == "" {
+		fixturesDir = "fixtures"
+	}
+	fixtures, err := fetcher.NewFixtures(os.Getenv("UPSTREAM_FIXTURES"), fixturesDir)
+	if err != nil {
+		log.Fatalf("Could not set up the upstream fixtures: %v", err)
+	}
+	if fixtures != nil {
+		log.Printf("Upstream traffic is in %s mode, with the fixtures in %s", fixtures.Mode, fixtures.Dir)
+	}
 	if dsn == "" {
 		dsn = "postgresql://root@localhost:26257/stocks_db?sslmode=disable"
 	}
@@ -108,15 +274,83 @@ func main() {
 		}
 	}()
 
+	// The check-consistency command checks the references to the stocks and exits instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "check-consistency" {
+		code := checkConsistency(os.Args[2:])
+		if err := models.CloseDB(); err != nil {
+			log.Printf("Error closing DB: %v", err)
+		}
+		os.Exit(code)
+	}
+
+	// The FX rates are replaced by the ones in the file on every start, and kept as they were when there is none
+	if fxRatesFile := os.Getenv("FX_RATES_FILE"); fxRatesFile != "" {
+		rates, err := models.LoadFxRatesFile(fxRatesFile)
+		if err != nil {
+			log.Fatalf("Invalid FX_RATES_FILE: %v", err)
+		}
+		if err := models.SaveFxRates(context.Background(), models.DB, rates); err != nil {
+			log.Fatalf("Failed to save the FX rates: %v", err)
+		}
+		log.Printf("Loaded %d FX rates from %s", len(rates.Rates), fxRatesFile)
+	}
+	if reportingCurrency := os.Getenv("REPORTING_CURRENCY"); reportingCurrency != "" {
+		presenter.ReportingCurrency = strings.ToUpper(strings.TrimSpace(reportingCurrency))
+	}
+
 	apiFetcher := fetcher.StockFetcher{
-		RatingsFetcher: &fetcher.BasicStockRatingsFetcher{DB: models.DB, BearerToken: ratingsApiToken},
-		InfoFetcher:    &fetcher.BasicStockInfoFetcher{DB: models.DB, BearerToken: infoApiToken},
+		RatingsFetcher: &fetcher.BasicStockRatingsFetcher{
+			DB:                  models.DB,
+			Retry:               retryPolicy,
+			RequestTimeout:      requestTimeout,
+			Limiter:             rateLimiter,
+			ConditionalRequests: conditionalRequests,
+			Incremental:         incrementalRatings,
+			Fixtures:            fixtures,
+			Sources: buildRatingsSources(fetcher.RatingsSourceConfig{
+				Retry:               retryPolicy,
+				RequestTimeout:      requestTimeout,
+				Limiter:             rateLimiter,
+				DB:                  models.DB,
+				ConditionalRequests: conditionalRequests,
+				Fixtures:            fixtures,
+			}, newBreaker),
+		},
+		InfoFetcher: &fetcher.BasicStockInfoFetcher{
+			DB:                  models.DB,
+			Auth:                buildAuthenticator("INFO_API", fixtures),
+			Retry:               retryPolicy,
+			RequestTimeout:      requestTimeout,
+			Limiter:             rateLimiter,
+			Breaker:             newBreaker("info"),
+			ConditionalRequests: conditionalRequests,
+			Workers:             infoFetchWorkers,
+			BatchSize:           infoBatchSize,
+			TickerSeparator:     infoTickerSeparator,
+			Fixtures:            fixtures,
+		},
 	}
 
 	analyzerPipeline := analyzer.BasicAnalyzerPipeline{}
 
-	go startPeriodicFetchAll(fetchDelaySeconds, ratingsApiUrl, infoApiUrl, &apiFetcher)
-	go startPeriodicAnalysis(analysisDelaySeconds, &analyzerPipeline)
+	// Cancelled on shutdown, so in-flight fetches stop along with the server
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Scheduled and triggered jobs share the runner, so jobs of the same kind never overlap
+	jobService := &jobs.Service{
+		Runner:       jobs.NewRunner(ctx),
+		DB:           models.DB,
+		Fetcher:      &apiFetcher,
+		Analyzer:     &analyzerPipeline,
+		RatingsUrl:   ratingsApiUrl,
+		InfoUrl:      infoApiUrl,
+		FetchTimeout: fetchTimeout,
+	}
+	presenter.Jobs = jobService
+
+	go startPeriodicFetchAll(ctx, fetchDelaySeconds, jobService)
+	go startPeriodicAnalysis(ctx, analysisDelaySeconds, jobService)
 
 	// Set up the Gin router
 	router := gin.Default()
@@ -132,10 +366,48 @@ func main() {
 	// Define routes
 	router.GET("/stocks", presenter.GetStocks)
 	router.GET("/stocks/:ticker", presenter.GetStockDetail)
+	router.GET("/stocks/:ticker/ratings/history", presenter.GetStockRatingHistory)
+	router.GET("/stocks/:ticker/prices", presenter.GetStockPrices)
+	router.GET("/health/upstreams", presenter.GetUpstreams)
+
+	// Pushed ratings are only accepted when a secret signs them
+	if ingestSecret := os.Getenv("INGEST_SECRET"); ingestSecret != "" {
+		router.POST("/ingest/ratings", presenter.RequireSignature(ingestSecret), presenter.IngestRatings)
+	} else {
+		log.Println("INGEST_SECRET is not set, the ingestion endpoint is disabled")
+	}
+
+	// Admin routes are only served when a token protects them
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		admin := router.Group("/admin", presenter.RequireAdminToken(adminToken))
+		admin.GET("/quarantine", presenter.GetQuarantinedRatings)
+		admin.POST("/quarantine/replay", presenter.ReplayQuarantinedRatings)
+		admin.GET("/fetch-runs", presenter.GetFetchRuns)
+		admin.GET("/fetch-runs/:id", presenter.GetFetchRun)
+		admin.POST("/jobs/fetch", presenter.QueueFetchJob)
+		admin.POST("/jobs/analysis", presenter.QueueAnalysisJob)
+		admin.GET("/jobs/:id", presenter.GetJob)
+		admin.GET("/brokerages", presenter.GetBrokerages)
+		admin.POST("/brokerages/merge", presenter.MergeBrokerages)
+		admin.DELETE("/stocks/:ticker", presenter.DeleteStock)
+	} else {
+		log.Println("ADMIN_TOKEN is not set, the admin endpoints are disabled")
+	}
 
 	// Start the server
+	server := &http.Server{Addr: "0.0.0.0:8080", Handler: router}
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutting down server...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error shutting down server: %v", err)
+		}
+	}()
+
 	log.Println("Server running at 0.0.0.0:8080")
-	if err := router.Run("0.0.0.0:8080"); err != nil {
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
@@ -0,0 +1,223 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Brokerage is the canonical entity of a brokerage. Its ratings are saved under its Name, whichever alias the feeds
+// give them under
+type Brokerage struct {
+	ID        uint   `gorm:"primaryKey"`
+	Name      string `gorm:"uniqueIndex;not null"`
+	CreatedAt time.Time
+}
+
+// BrokerageAlias maps a spelling of a brokerage name to its canonical brokerage. Spellings that only differ in case,
+// punctuation or corporate suffixes share the same AliasKey
+type BrokerageAlias struct {
+	AliasKey    string `gorm:"primaryKey"`
+	Alias       string
+	BrokerageID uint `gorm:"index"`
+	CreatedAt   time.Time
+}
+
+// brokerageSuffixes are the corporate suffixes left out of the alias keys, as in "Wells Fargo & Company"
+var brokerageSuffixes = []string{"and company", "and co", "company", "co", "incorporated", "inc", "llc", "llp", "lp",
+	"limited", "ltd", "plc", "corporation", "corp", "group", "ag", "sa", "nv"}
+
+// BrokerageAliasKey normalizes a brokerage name into the key of its alias: lower case, without punctuation, corporate
+// suffixes nor spaces. "Wells Fargo & Company" and "Wells Fargo" share "wellsfargo"
+func BrokerageAliasKey(name string) string {
+	name = strings.ToLower(strings.ReplaceAll(name, "&", " and "))
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	joined := " " + strings.Join(words, " ")
+
+	for stripped := true; stripped; {
+		stripped = false
+		for _, suffix := range brokerageSuffixes {
+			// the name itself is never stripped away
+			if rest, ok := strings.CutSuffix(joined, " "+suffix); ok && strings.TrimSpace(rest) != "" {
+				joined, stripped = rest, true
+			}
+		}
+	}
+	return strings.ReplaceAll(joined, " ", "")
+}
+
+// ResolveBrokerage gets the canonical brokerage of a name. A name never seen before is registered as a brokerage of
+// its own, unless its alias key matches a known brokerage
+func ResolveBrokerage(db *gorm.DB, name string) (Brokerage, error) {
+	name = strings.TrimSpace(name)
+	key := BrokerageAliasKey(name)
+	if key == "" {
+		return Brokerage{Name: name}, nil
+	}
+
+	var alias BrokerageAlias
+	err := db.Where("alias_key = ?", key).First(&alias).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// concurrent registrations of the same name settle on whichever was created first
+		brokerage := Brokerage{Name: name}
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&brokerage).Error; err != nil {
+			return Brokerage{}, err
+		}
+		if err := db.Where("name = ?", name).First(&brokerage).Error; err != nil {
+			return Brokerage{}, err
+		}
+		alias = BrokerageAlias{AliasKey: key, Alias: name, BrokerageID: brokerage.ID}
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&alias).Error; err != nil {
+			return Brokerage{}, err
+		}
+		err = db.Where("alias_key = ?", key).First(&alias).Error
+	}
+	if err != nil {
+		return Brokerage{}, err
+	}
+
+	var brokerage Brokerage
+	err = db.First(&brokerage, alias.BrokerageID).Error
+	return brokerage, err
+}
+
+// CanonicalBrokerageName gets the name the ratings of a brokerage are saved under, given any of its aliases. Unlike
+// ResolveBrokerage, names that match no known brokerage are returned as they are, without registering them
+func CanonicalBrokerageName(db *gorm.DB, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	key := BrokerageAliasKey(name)
+	if key == "" {
+		return name, nil
+	}
+
+	var brokerage Brokerage
+	err := db.Joins("JOIN brokerage_aliases ON brokerage_aliases.brokerage_id = brokerages.id").
+		Where("brokerage_aliases.alias_key = ?", key).First(&brokerage).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return name, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return brokerage.Name, nil
+}
+
+// BrokerageMerge is the outcome of merging a brokerage into another
+type BrokerageMerge struct {
+	Into Brokerage
+	// Revisions counts the revisions re-keyed to the merged brokerage, and Tickers lists the stocks they rate
+	Revisions int64
+	Tickers   []string
+}
+
+// MergeBrokerages merges a brokerage into another. Its aliases move to the other brokerage, and its ratings are
+// re-keyed to the name of the other brokerage, all in a single transaction
+func MergeBrokerages(ctx context.Context, db *gorm.DB, sourceID uint, targetID uint) (BrokerageMerge, error) {
+	if sourceID == targetID {
+		return BrokerageMerge{}, fmt.Errorf("can't merge brokerage %d into itself", sourceID)
+	}
+
+	var merge BrokerageMerge
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var source, target Brokerage
+		if err := tx.First(&source, sourceID).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&target, targetID).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&BrokerageAlias{}).Where("brokerage_id = ?", source.ID).Update("brokerage_id", target.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&source).Error; err != nil {
+			return err
+		}
+
+		revisions, tickers, err := RekeyBrokerage(tx, source.Name, target.Name)
+		if err != nil {
+			return err
+		}
+		merge = BrokerageMerge{Into: target, Revisions: revisions, Tickers: tickers}
+		return nil
+	})
+	return merge, err
+}
+
+// RekeyBrokerage moves the ratings saved under a brokerage name to another, deriving the current ratings again from
+// the merged history. Returns the amount of revisions moved and the tickers they rate
+func RekeyBrokerage(db *gorm.DB, from string, to string) (int64, []string, error) {
+	var tickers []string
+	if err := db.Model(&StockRatingRevision{}).Where("brokerage = ?", from).Distinct().Order("ticker").Pluck("ticker", &tickers).Error; err != nil {
+		return 0, nil, err
+	}
+
+	result := db.Model(&StockRatingRevision{}).Where("brokerage = ?", from).Update("brokerage", to)
+	if result.Error != nil {
+		return 0, nil, result.Error
+	}
+	if err := db.Where("brokerage = ?", from).Delete(&StockRating{}).Error; err != nil {
+		return 0, nil, err
+	}
+	for _, ticker := range tickers {
+		if err := RefreshCurrentRating(db, ticker, to); err != nil {
+			return 0, nil, err
+		}
+	}
+	return result.RowsAffected, tickers, nil
+}
+
+// RefreshCurrentRating derives the current rating of a broker for a stock from its latest revision
+func RefreshCurrentRating(db *gorm.DB, ticker string, brokerage string) error {
+	var latest StockRatingRevision
+	err := db.Where("ticker = ? AND brokerage = ?", ticker, brokerage).
+		Order("time desc, id desc").
+		First(&latest).Error
+	if err != nil {
+		return err
+	}
+
+	current := latest.Rating()
+	return db.Save(&current).Error
+}
+
+// CanonicalizeBrokerages registers every brokerage name found in the rating history, re-keying the ratings of the
+// names whose alias matches another brokerage. The most used spelling of a brokerage becomes its canonical name
+func CanonicalizeBrokerages(db *gorm.DB) error {
+	var names []string
+	err := db.Model(&StockRatingRevision{}).
+		Select("brokerage").
+		Group("brokerage").
+		Order("count(*) desc, brokerage").
+		Pluck("brokerage", &names).Error
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, name := range names {
+			brokerage, err := ResolveBrokerage(tx, name)
+			if err != nil {
+				return err
+			}
+			if brokerage.Name == name {
+				continue
+			}
+
+			revisions, _, err := RekeyBrokerage(tx, name, brokerage.Name)
+			if err != nil {
+				return err
+			}
+			log.Printf("Re-keyed %d ratings of %q to %q", revisions, name, brokerage.Name)
+		}
+		return nil
+	})
+}
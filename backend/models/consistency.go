@@ -0,0 +1,85 @@
+package models
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"slices"
+)
+
+// ConsistencyReport tells which rows reference a stock that doesn't exist
+type ConsistencyReport struct {
+	// OrphanTickers are the tickers rated or quoted without a stock
+	OrphanTickers   []string
+	OrphanRatings   int64
+	OrphanRevisions int64
+	OrphanSnapshots int64
+	// Repaired tells whether placeholder stocks were created for the orphans
+	Repaired bool
+}
+
+// Consistent tells whether every row references an existing stock
+func (r ConsistencyReport) Consistent() bool {
+	return len(r.OrphanTickers) == 0
+}
+
+// CheckConsistency looks for the current ratings, rating history and price history of stocks that don't exist.
+// With repair, placeholder stocks are created for them, so nothing recorded is lost and the next fetch fills them in
+func CheckConsistency(ctx context.Context, db *gorm.DB, repair bool) (ConsistencyReport, error) {
+	db = db.WithContext(ctx)
+	report := ConsistencyReport{}
+
+	for _, orphans := range []struct {
+		model any
+		count *int64
+	}{
+		{&StockRating{}, &report.OrphanRatings},
+		{&StockRatingRevision{}, &report.OrphanRevisions},
+		{&PriceSnapshot{}, &report.OrphanSnapshots},
+	} {
+		query := db.Model(orphans.model).Where("ticker NOT IN (?)", db.Model(&Stock{}).Select("ticker"))
+		if err := query.Count(orphans.count).Error; err != nil {
+			return report, err
+		}
+
+		var tickers []string
+		query = db.Model(orphans.model).Where("ticker NOT IN (?)", db.Model(&Stock{}).Select("ticker"))
+		if err := query.Distinct().Pluck("ticker", &tickers).Error; err != nil {
+			return report, err
+		}
+		for _, ticker := range tickers {
+			if !slices.Contains(report.OrphanTickers, ticker) {
+				report.OrphanTickers = append(report.OrphanTickers, ticker)
+			}
+		}
+	}
+	slices.Sort(report.OrphanTickers)
+
+	if !repair || report.Consistent() {
+		return report, nil
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, ticker := range report.OrphanTickers {
+			if err := EnsureStock(tx, ticker, ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	report.Repaired = true
+	return report, nil
+}
+
+// countOrphanRatings counts the current ratings without a stock, before the foreign key between them is migrated.
+// It only reads the columns the first schema had, as the rest may not be migrated yet
+func countOrphanRatings(db *gorm.DB) (int64, error) {
+	if !db.Migrator().HasTable(&Stock{}) || !db.Migrator().HasTable(&StockRating{}) {
+		return 0, nil
+	}
+
+	var count int64
+	err := db.Raw("SELECT COUNT(*) FROM stock_ratings WHERE ticker NOT IN (SELECT ticker FROM stocks)").Scan(&count).Error
+	return count, err
+}
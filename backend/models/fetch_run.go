@@ -0,0 +1,57 @@
+package models
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Outcomes of a fetch run
+const (
+	// FetchRunRunning is the outcome of a run that didn't finish yet, or that was interrupted before recording its end
+	FetchRunRunning = "running"
+	// FetchRunSucceeded is the outcome of a run that fetched and saved everything
+	FetchRunSucceeded = "succeeded"
+	// FetchRunPartial is the outcome of a run that completed, but where some pages or tickers failed
+	FetchRunPartial = "partial"
+	// FetchRunFailed is the outcome of a run where a whole stage couldn't complete
+	FetchRunFailed = "failed"
+)
+
+// FetchRun records a run of the fetcher, from its start to its outcome
+type FetchRun struct {
+	ID         uint      `gorm:"primaryKey"`
+	StartedAt  time.Time `gorm:"index"`
+	FinishedAt *time.Time
+	Outcome    string `gorm:"index"`
+
+	PagesFetched     int
+	PagesUnchanged   int
+	PagesFailed      int
+	RatingsSaved     int
+	RatingsSkipped   int
+	TickersRefreshed int
+	TickersUnchanged int
+	TickersSkipped   int
+	TickersFailed    int
+
+	// Error is the error of the stage that couldn't complete, if any
+	Error string `gorm:"type:text"`
+	// ProblemsJSON holds a JSON list of the first problems of the run. ProblemCount counts all of them
+	ProblemsJSON string `gorm:"type:text"`
+	ProblemCount int
+}
+
+// StartFetchRun records that a fetch run started
+func StartFetchRun(ctx context.Context, db *gorm.DB) (FetchRun, error) {
+	run := FetchRun{StartedAt: time.Now(), Outcome: FetchRunRunning}
+	err := db.WithContext(ctx).Create(&run).Error
+	return run, err
+}
+
+// FinishFetchRun records the end and the outcome of a fetch run
+func FinishFetchRun(ctx context.Context, db *gorm.DB, run *FetchRun) error {
+	finishedAt := time.Now()
+	run.FinishedAt = &finishedAt
+	return db.WithContext(ctx).Save(run).Error
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultCurrency is the currency of the prices and targets that don't state one
+const DefaultCurrency = "USD"
+
+// FxRate is the amount of a currency worth one unit of the base currency of the FX rate table
+type FxRate struct {
+	Currency  string `gorm:"primaryKey"`
+	Base      string
+	Rate      float64
+	UpdatedAt time.Time
+}
+
+// FxRates is an FX rate table, giving for each currency the amount of it worth one unit of Base
+type FxRates struct {
+	Base  string             `json:"base"`
+	Rates map[string]float64 `json:"rates"`
+}
+
+// LoadFxRatesFile reads an FX rate table from a JSON file such as {"base": "USD", "rates": {"EUR": 0.92}}
+func LoadFxRatesFile(path string) (FxRates, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return FxRates{}, err
+	}
+
+	var rates FxRates
+	if err := json.Unmarshal(content, &rates); err != nil {
+		return FxRates{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if rates.Base == "" {
+		return FxRates{}, fmt.Errorf("%s has no base currency", path)
+	}
+
+	normalized := FxRates{Base: strings.ToUpper(rates.Base), Rates: make(map[string]float64, len(rates.Rates))}
+	for currency, rate := range rates.Rates {
+		if rate <= 0 {
+			return FxRates{}, fmt.Errorf("%s has an invalid rate for %s", path, currency)
+		}
+		normalized.Rates[strings.ToUpper(currency)] = rate
+	}
+	return normalized, nil
+}
+
+// SaveFxRates replaces the FX rate table
+func SaveFxRates(ctx context.Context, db *gorm.DB, rates FxRates) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&FxRate{}).Error; err != nil {
+			return err
+		}
+		for currency, rate := range rates.Rates {
+			if err := tx.Create(&FxRate{Currency: currency, Base: rates.Base, Rate: rate}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetFxRates retrieves the FX rate table. An empty table only converts amounts to their own currency
+func GetFxRates(ctx context.Context, db *gorm.DB) (FxRates, error) {
+	var rows []FxRate
+	if err := db.WithContext(ctx).Find(&rows).Error; err != nil {
+		return FxRates{}, err
+	}
+
+	rates := FxRates{Rates: make(map[string]float64, len(rows))}
+	for _, row := range rows {
+		rates.Base = row.Base
+		rates.Rates[row.Currency] = row.Rate
+	}
+	return rates, nil
+}
+
+// rate gets the amount of a currency worth one unit of the base currency
+func (r FxRates) rate(currency string) (float64, bool) {
+	if currency == r.Base {
+		return 1, true
+	}
+	rate, ok := r.Rates[currency]
+	return rate, ok
+}
+
+// Convert converts an amount between two currencies. Amounts without a currency are in DefaultCurrency
+func (r FxRates) Convert(amount float64, from string, to string) (float64, error) {
+	from, to = normalizeCurrency(from), normalizeCurrency(to)
+	if from == to {
+		return amount, nil
+	}
+
+	fromRate, ok := r.rate(from)
+	if !ok {
+		return 0, fmt.Errorf("no FX rate for %s", from)
+	}
+	toRate, ok := r.rate(to)
+	if !ok {
+		return 0, fmt.Errorf("no FX rate for %s", to)
+	}
+	return amount / fromRate * toRate, nil
+}
+
+// normalizeCurrency upper cases a currency code, defaulting to DefaultCurrency
+func normalizeCurrency(currency string) string {
+	if currency == "" {
+		return DefaultCurrency
+	}
+	return strings.ToUpper(currency)
+}
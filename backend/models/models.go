@@ -10,16 +10,17 @@ import (
 
 // NewTestDB sets up an in-memory SQLite database and populates it with stock ratings for testing
 func NewTestDB(stockRatings []StockRating) *gorm.DB {
-	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	db, err := gorm.Open(sqlite.Open(":memory:?_foreign_keys=1"), &gorm.Config{})
 	if err != nil {
 		panic("failed to connect to in-memory database")
 	}
 
 	// Migrate the schema
-	_ = db.AutoMigrate(&Stock{}, &StockRating{})
+	_ = db.AutoMigrate(&Stock{}, &StockRating{}, &StockRatingRevision{}, &RatingsSyncState{}, &ResponseValidator{}, &QuarantinedRating{}, &FxRate{}, &PriceSnapshot{}, &FetchRun{}, &Brokerage{}, &BrokerageAlias{})
 
-	// Insert the stock ratings into the test DB
+	// Insert the stock ratings into the test DB, along with the stocks they reference
 	for _, rating := range stockRatings {
+		_ = EnsureStock(db, rating.Ticker, "")
 		db.Create(&rating)
 	}
 
@@ -48,15 +49,62 @@ func ConnectDB(dsn string, dbInstance ...*gorm.DB) error {
 
 	log.Println("✅ Connected to the database!")
 
+	// ratings saved before they referenced their stock are left for check-consistency to report and repair, and the
+	// foreign key to their stock is only added once there are none
+	migrator := DB
+	orphans, err := countOrphanRatings(DB)
+	if err != nil {
+		return fmt.Errorf("failed to look for the ratings without a stock: %v", err)
+	}
+	if orphans > 0 {
+		log.Printf("%d ratings reference a stock that doesn't exist, run check-consistency -repair to reference them", orphans)
+		migrator = withoutForeignKeys(DB)
+	}
+
+	// the revisions saved before they were keyed get their key before it is required and unique
+	err = MigrateRevisionKeys(DB)
+	if err != nil {
+		return fmt.Errorf("failed to key the rating history: %v", err)
+	}
+
 	// Auto-migrate schemas
-	err = DB.AutoMigrate(&Stock{}, &StockRating{})
+	err = migrator.AutoMigrate(&Stock{}, &StockRating{}, &StockRatingRevision{}, &RatingsSyncState{}, &ResponseValidator{}, &QuarantinedRating{}, &FxRate{}, &PriceSnapshot{}, &FetchRun{}, &Brokerage{}, &BrokerageAlias{})
 	if err != nil {
 		return fmt.Errorf("failed to auto-migrate: %v", err)
 	}
 
+	err = BackfillRatingHistory(DB)
+	if err != nil {
+		return fmt.Errorf("failed to backfill the rating history: %v", err)
+	}
+
+	err = NormalizeStoredTickers(DB)
+	if err != nil {
+		return fmt.Errorf("failed to normalize the tickers: %v", err)
+	}
+
+	err = CanonicalizeBrokerages(DB)
+	if err != nil {
+		return fmt.Errorf("failed to canonicalize the brokerages: %v", err)
+	}
+
+	err = BackfillRatingActionTypes(DB)
+	if err != nil {
+		return fmt.Errorf("failed to type the rating actions: %v", err)
+	}
+
 	return nil
 }
 
+// withoutForeignKeys gets a session of the database that migrates the schemas without their foreign keys
+func withoutForeignKeys(db *gorm.DB) *gorm.DB {
+	config := *db.Config
+	config.DisableForeignKeyConstraintWhenMigrating = true
+	session := db.Session(&gorm.Session{})
+	session.Config = &config
+	return session
+}
+
 // CloseDB closes the database connection (default or mockable)
 func CloseDB() error {
 	if DB == nil {
@@ -86,3 +134,91 @@ func GetAllStocks() ([]Stock, error) {
 
 	return stocks, nil
 }
+
+// BackfillRatingHistory seeds the rating history with the current ratings when it is empty, so the ratings saved
+// before the history existed are not lost
+func BackfillRatingHistory(db *gorm.DB) error {
+	var revisions int64
+	if err := db.Model(&StockRatingRevision{}).Count(&revisions).Error; err != nil {
+		return err
+	}
+	if revisions > 0 {
+		return nil
+	}
+
+	var ratings []StockRating
+	if err := db.Find(&ratings).Error; err != nil {
+		return err
+	}
+	if len(ratings) == 0 {
+		return nil
+	}
+
+	log.Printf("Backfilling the rating history with %d ratings", len(ratings))
+	history := make([]StockRatingRevision, len(ratings))
+	for i, rating := range ratings {
+		history[i] = NewStockRatingRevision(rating)
+	}
+	return db.CreateInBatches(history, 100).Error
+}
+
+// MigrateRevisionKeys keys the revisions saved before revisions had a revision key. The column is added as nullable,
+// each revision without a key gets its content key, and only then is the column made required and unique. Revisions
+// recorded twice get the same key, so only the first one is kept
+func MigrateRevisionKeys(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&StockRatingRevision{}) {
+		return nil
+	}
+	if !migrator.HasColumn(&StockRatingRevision{}, "RevisionKey") {
+		log.Printf("Adding the revision keys to the rating history")
+		if err := db.Exec("ALTER TABLE stock_rating_revisions ADD COLUMN revision_key text").Error; err != nil {
+			return err
+		}
+	}
+
+	var missing int64
+	if err := db.Model(&StockRatingRevision{}).Where("revision_key IS NULL OR revision_key = ''").Count(&missing).Error; err != nil {
+		return err
+	}
+	if missing == 0 {
+		return nil
+	}
+
+	log.Printf("Backfilling the revision keys of %d revisions", missing)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		keyed := make(map[string]bool)
+		var duplicates []uint
+		var revisions []StockRatingRevision
+		result := tx.Where("revision_key IS NULL OR revision_key = ''").Order("id").FindInBatches(&revisions, 100, func(*gorm.DB, int) error {
+			for _, revision := range revisions {
+				key := StockRatingContentKey(revision.Rating())
+				if keyed[key] {
+					duplicates = append(duplicates, revision.ID)
+					continue
+				}
+				keyed[key] = true
+				if err := tx.Model(&StockRatingRevision{}).Where("id = ?", revision.ID).Update("revision_key", key).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if len(duplicates) > 0 {
+			log.Printf("Removing %d revisions recorded twice", len(duplicates))
+			return tx.Where("id IN ?", duplicates).Delete(&StockRatingRevision{}).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := migrator.AlterColumn(&StockRatingRevision{}, "RevisionKey"); err != nil {
+		return err
+	}
+	return migrator.CreateIndex(&StockRatingRevision{}, "RevisionKey")
+}
@@ -1,9 +1,13 @@
 package models
 
 import (
+	"context"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -60,3 +64,406 @@ func TestCloseDB_Failure(t *testing.T) {
 	assert.NoError(t, err, "Error was not returned upon close DB")
 	assert.NotNil(t, DB, "DB should still exist even after close failure")
 }
+
+// TestBackfillRatingHistory ensures ratings saved before the history existed are copied into it once
+func TestBackfillRatingHistory(t *testing.T) {
+	db := NewTestDB([]StockRating{
+		{Ticker: "AAPL", Brokerage: "A", RatingTo: "Buy"},
+		{Ticker: "AAPL", Brokerage: "B", RatingTo: "Sell"},
+	})
+
+	err := BackfillRatingHistory(db)
+	assert.NoError(t, err)
+	err = BackfillRatingHistory(db)
+	assert.NoError(t, err)
+
+	var history []StockRatingRevision
+	db.Order("brokerage").Find(&history)
+	assert.Len(t, history, 2)
+	assert.Equal(t, "Buy", history[0].RatingTo)
+	assert.Equal(t, "Sell", history[1].RatingTo)
+}
+
+// TestMigrateRevisionKeys ensures a rating history saved before revisions were keyed gets its keys on connect
+func TestMigrateRevisionKeys(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "legacy.db")), &gorm.Config{})
+	assert.NoError(t, err)
+
+	// the schema and data of the rating history before it was keyed
+	type legacyRating struct {
+		Ticker     string `gorm:"primaryKey"`
+		Brokerage  string `gorm:"primaryKey"`
+		TargetFrom float64
+		TargetTo   float64
+		Action     string
+		RatingFrom string
+		RatingTo   string
+		Time       time.Time
+	}
+	type legacyRevision struct {
+		ID         uint   `gorm:"primaryKey"`
+		Ticker     string `gorm:"index:idx_revision_ticker_brokerage"`
+		Brokerage  string `gorm:"index:idx_revision_ticker_brokerage"`
+		TargetFrom float64
+		TargetTo   float64
+		Action     string
+		RatingFrom string
+		RatingTo   string
+		Time       time.Time
+		CreatedAt  time.Time
+	}
+	assert.NoError(t, db.AutoMigrate(&Stock{}))
+	assert.NoError(t, db.Table("stock_ratings").AutoMigrate(&legacyRating{}))
+	assert.NoError(t, db.Table("stock_rating_revisions").AutoMigrate(&legacyRevision{}))
+
+	ratedAt := time.Date(2025, 1, 13, 0, 0, 0, 0, time.UTC)
+	legacy := []legacyRevision{
+		{Ticker: "AAPL", Brokerage: "A", RatingTo: "Neutral", TargetTo: 200, Time: ratedAt},
+		{Ticker: "AAPL", Brokerage: "A", RatingTo: "Buy", TargetTo: 250, Time: ratedAt.AddDate(0, 1, 0)},
+		// the same rating recorded twice
+		{Ticker: "AAPL", Brokerage: "A", RatingTo: "Neutral", TargetTo: 200, Time: ratedAt},
+	}
+	assert.NoError(t, db.Table("stock_rating_revisions").Create(&legacy).Error)
+	assert.NoError(t, db.Table("stock_ratings").Create(&legacyRating{Ticker: "AAPL", Brokerage: "A", RatingTo: "Buy", TargetTo: 250, Time: ratedAt.AddDate(0, 1, 0)}).Error)
+
+	err = ConnectDB("", db)
+	assert.NoError(t, err)
+
+	var revisions []StockRatingRevision
+	db.Order("id").Find(&revisions)
+	assert.Len(t, revisions, 2)
+	assert.Equal(t, legacy[0].ID, revisions[0].ID)
+	assert.Equal(t, StockRatingContentKey(StockRating{Ticker: "AAPL", Brokerage: "A", RatingTo: "Neutral", TargetTo: 200, Time: ratedAt}), revisions[0].RevisionKey)
+	assert.Equal(t, StockRatingContentKey(StockRating{Ticker: "AAPL", Brokerage: "A", RatingTo: "Buy", TargetTo: 250, Time: ratedAt.AddDate(0, 1, 0)}), revisions[1].RevisionKey)
+
+	// the key is now required and unique
+	assert.True(t, db.Migrator().HasIndex(&StockRatingRevision{}, "RevisionKey"))
+	columns, err := db.Migrator().ColumnTypes(&StockRatingRevision{})
+	assert.NoError(t, err)
+	for _, column := range columns {
+		if column.Name() == "revision_key" {
+			nullable, _ := column.Nullable()
+			assert.False(t, nullable)
+		}
+	}
+	assert.Error(t, db.Exec("INSERT INTO stock_rating_revisions (ticker, brokerage) VALUES ('AAPL', 'A')").Error)
+	assert.Error(t, db.Exec("INSERT INTO stock_rating_revisions (ticker, brokerage, revision_key) VALUES ('AAPL', 'A', ?)", revisions[0].RevisionKey).Error)
+}
+
+// TestFxRates ensures the FX rates are loaded from a file and convert amounts through the base currency
+func TestFxRates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fx.json")
+	err := os.WriteFile(path, []byte(`{"base": "usd", "rates": {"EUR": 0.8, "gbp": 0.5}}`), 0o644)
+	assert.NoError(t, err)
+
+	loaded, err := LoadFxRatesFile(path)
+	assert.NoError(t, err)
+
+	db := NewTestDB(nil)
+	assert.NoError(t, SaveFxRates(context.Background(), db, loaded))
+	rates, err := GetFxRates(context.Background(), db)
+	assert.NoError(t, err)
+	assert.Equal(t, "USD", rates.Base)
+
+	amount, err := rates.Convert(80, "EUR", "USD")
+	assert.NoError(t, err)
+	assert.InDelta(t, 100, amount, 1e-9)
+	amount, err = rates.Convert(80, "EUR", "GBP")
+	assert.NoError(t, err)
+	assert.InDelta(t, 50, amount, 1e-9)
+	amount, err = rates.Convert(7, "JPY", "jpy")
+	assert.NoError(t, err)
+	assert.Equal(t, 7.0, amount)
+	_, err = rates.Convert(7, "JPY", "USD")
+	assert.Error(t, err)
+
+	err = os.WriteFile(path, []byte(`{"rates": {"EUR": 0.8}}`), 0o644)
+	assert.NoError(t, err)
+	_, err = LoadFxRatesFile(path)
+	assert.Error(t, err, "a table without a base currency is rejected")
+}
+
+// TestBucketPriceSnapshots ensures the snapshots are summarized per interval
+func TestBucketPriceSnapshots(t *testing.T) {
+	start := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+	snapshots := []PriceSnapshot{
+		{LastPrice: 10, FetchedAt: start.Add(1 * time.Hour)},
+		{LastPrice: 12, FetchedAt: start.Add(2 * time.Hour)},
+		{LastPrice: 9, FetchedAt: start.Add(3 * time.Hour)},
+		{LastPrice: 11, FetchedAt: start.Add(26 * time.Hour)},
+	}
+
+	buckets := BucketPriceSnapshots(snapshots, func(t time.Time) time.Time { return t.Truncate(24 * time.Hour) })
+	assert.Len(t, buckets, 2)
+	assert.Equal(t, PriceBucket{Start: start, Open: 10, High: 12, Low: 9, Close: 9, Samples: 3}, buckets[0])
+	assert.Equal(t, PriceBucket{Start: start.Add(24 * time.Hour), Open: 11, High: 11, Low: 11, Close: 11, Samples: 1}, buckets[1])
+}
+
+// TestBrokerageAliasKey ensures spellings of a brokerage that only differ in case, punctuation or suffixes match
+func TestBrokerageAliasKey(t *testing.T) {
+	assert.Equal(t, "wellsfargo", BrokerageAliasKey("Wells Fargo & Company"))
+	assert.Equal(t, "wellsfargo", BrokerageAliasKey("Wells Fargo"))
+	assert.Equal(t, "jpmorganchase", BrokerageAliasKey("JPMorgan Chase & Co."))
+	assert.Equal(t, "jpmorgan", BrokerageAliasKey("JP Morgan"))
+	assert.Equal(t, "goldmansachs", BrokerageAliasKey("Goldman Sachs Group, Inc."))
+	assert.Equal(t, "group", BrokerageAliasKey("Group"))
+}
+
+// TestMergeBrokerages ensures the ratings of merged brokerages are re-keyed, keeping the latest as the current one
+func TestMergeBrokerages(t *testing.T) {
+	db := NewTestDB(nil)
+	db.Create(&Stock{Ticker: "AAPL"})
+	older := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	db.Create(&[]StockRatingRevision{
+		{RevisionKey: "a", Ticker: "AAPL", Brokerage: "JPMorgan Chase & Co.", RatingTo: "Neutral", Time: older},
+		{RevisionKey: "b", Ticker: "AAPL", Brokerage: "JP Morgan", RatingTo: "Overweight", Time: older.AddDate(0, 0, 1)},
+		{RevisionKey: "c", Ticker: "AAPL", Brokerage: "JPMorgan Chase", RatingTo: "Neutral", Time: older},
+	})
+	db.Create(&[]StockRating{
+		{Ticker: "AAPL", Brokerage: "JPMorgan Chase & Co.", RatingTo: "Neutral", Time: older},
+		{Ticker: "AAPL", Brokerage: "JP Morgan", RatingTo: "Overweight", Time: older.AddDate(0, 0, 1)},
+		{Ticker: "AAPL", Brokerage: "JPMorgan Chase", RatingTo: "Neutral", Time: older},
+	})
+
+	// spellings sharing an alias key are merged right away
+	assert.NoError(t, CanonicalizeBrokerages(db))
+	var brokerages []Brokerage
+	db.Order("name").Find(&brokerages)
+	assert.Len(t, brokerages, 2)
+
+	var source, target Brokerage
+	db.Where("name = ?", "JP Morgan").First(&source)
+	db.Where("name <> ?", "JP Morgan").First(&target)
+	merge, err := MergeBrokerages(context.Background(), db, source.ID, target.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), merge.Revisions)
+	assert.Equal(t, []string{"AAPL"}, merge.Tickers)
+
+	var current []StockRating
+	db.Find(&current)
+	assert.Len(t, current, 1)
+	assert.Equal(t, target.Name, current[0].Brokerage)
+	assert.Equal(t, "Overweight", current[0].RatingTo)
+
+	resolved, err := ResolveBrokerage(db, "J.P. Morgan")
+	assert.NoError(t, err)
+	assert.Equal(t, target.ID, resolved.ID)
+
+	// looking a name up resolves its aliases without registering the unknown ones
+	name, err := CanonicalBrokerageName(db, "JP Morgan")
+	assert.NoError(t, err)
+	assert.Equal(t, target.Name, name)
+	name, err = CanonicalBrokerageName(db, "Unknown Securities")
+	assert.NoError(t, err)
+	assert.Equal(t, "Unknown Securities", name)
+	var count int64
+	db.Model(&Brokerage{}).Where("name = ?", "Unknown Securities").Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+// TestClassifyRatingAction ensures the action phrases of the feeds are typed, along with their direction
+func TestClassifyRatingAction(t *testing.T) {
+	cases := []struct {
+		action     string
+		from, to   float64
+		actionType RatingActionType
+		direction  string
+	}{
+		{"upgraded by", 0, 0, ActionUpgrade, DirectionUp},
+		{"downgraded by", 0, 0, ActionDowngrade, DirectionDown},
+		{"initiated by", 0, 0, ActionInitiation, DirectionNeutral},
+		{"reiterated by", 0, 0, ActionReiteration, DirectionNeutral},
+		{"target raised by", 10, 12, ActionTargetRaise, DirectionUp},
+		{"target lowered by", 12, 10, ActionTargetCut, DirectionDown},
+		{"coverage dropped by", 0, 0, ActionCoverageDropped, DirectionNeutral},
+		{"target set by", 12, 10, ActionTargetCut, DirectionDown},
+		{"target set by", 10, 10, ActionReiteration, DirectionNeutral},
+	}
+	for _, c := range cases {
+		actionType, ok := ClassifyRatingAction(c.action, c.from, c.to)
+		assert.True(t, ok, c.action)
+		assert.Equal(t, c.actionType, actionType, c.action)
+		assert.Equal(t, c.direction, actionType.Direction(), c.action)
+	}
+
+	actionType, ok := ClassifyRatingAction("mentioned by", 0, 0)
+	assert.False(t, ok)
+	assert.Equal(t, ActionUnknown, actionType)
+}
+
+// TestBackfillRatingActionTypes ensures the ratings saved before the actions were typed get their type
+func TestBackfillRatingActionTypes(t *testing.T) {
+	db := NewTestDB([]StockRating{
+		{Ticker: "AAPL", Brokerage: "A", Action: "target set by", TargetFrom: 10, TargetTo: 12},
+		{Ticker: "AAPL", Brokerage: "B", Action: "upgraded by"},
+	})
+	db.Create(&StockRatingRevision{RevisionKey: "a", Ticker: "AAPL", Brokerage: "A", Action: "target set by", TargetFrom: 10, TargetTo: 12})
+
+	assert.NoError(t, BackfillRatingActionTypes(db))
+
+	var current []StockRating
+	db.Order("brokerage").Find(&current)
+	assert.Equal(t, ActionTargetRaise, current[0].ActionType)
+	assert.Equal(t, ActionUpgrade, current[1].ActionType)
+	var revision StockRatingRevision
+	db.First(&revision)
+	assert.Equal(t, ActionTargetRaise, revision.ActionType)
+}
+
+// TestNormalizeTicker ensures the symbols of every vendor give the same ticker, and junk is rejected
+func TestNormalizeTicker(t *testing.T) {
+	for symbol, expected := range map[string]string{
+		"AAPL":   "AAPL",
+		" aapl ": "AAPL",
+		"BRK.B":  "BRK.B",
+		"brk-b":  "BRK.B",
+		"BRK/B":  "BRK.B",
+		"BRK B":  "BRK.B",
+		"RDS.A":  "RDS.A",
+	} {
+		ticker, err := NormalizeTicker(symbol)
+		assert.NoError(t, err, symbol)
+		assert.Equal(t, expected, ticker, symbol)
+	}
+
+	for _, symbol := range []string{"", "  ", "N/A", "null", "TBD", "1ABC", "TOOLONGX", "BRK..B", "BRK.B.C", "AA$PL"} {
+		_, err := NormalizeTicker(symbol)
+		assert.ErrorIs(t, err, ErrInvalidTicker, symbol)
+	}
+
+	assert.Equal(t, "BRK-B", VendorTicker("BRK.B", "-"))
+	assert.Equal(t, "BRK.B", VendorTicker("BRK.B", ""))
+}
+
+// TestNormalizeStoredTickers ensures the tickers saved before normalization are re-keyed, merging into the canonical
+// ones
+func TestNormalizeStoredTickers(t *testing.T) {
+	db := NewTestDB(nil)
+	db.Create(&Stock{Ticker: "brk-b", Company: "Berkshire Hathaway"})
+	db.Create(&Stock{Ticker: "BRK.B", Company: "Berkshire Hathaway Inc."})
+	rating := StockRating{Ticker: "brk-b", Brokerage: "A", RatingTo: "Buy", Time: time.Date(2025, 1, 13, 0, 0, 0, 0, time.UTC)}
+	rating.RevisionKey = StockRatingContentKey(rating)
+	db.Create(&rating)
+	revision := NewStockRatingRevision(rating)
+	db.Create(&revision)
+	db.Create(&PriceSnapshot{Ticker: "brk-b", LastPrice: 480})
+	db.Create(&Stock{Ticker: "N/A"})
+	// the same rating was also saved under the canonical ticker
+	other := StockRating{Ticker: "brk-b", Brokerage: "B", RatingTo: "Sell", Time: time.Date(2025, 1, 14, 0, 0, 0, 0, time.UTC)}
+	other.RevisionKey = StockRatingContentKey(other)
+	otherRevision := NewStockRatingRevision(other)
+	db.Create(&otherRevision)
+	other.Ticker = "BRK.B"
+	otherRevision = NewStockRatingRevision(other)
+	db.Create(&otherRevision)
+
+	assert.NoError(t, NormalizeStoredTickers(db))
+
+	var stocks []string
+	db.Model(&Stock{}).Order("ticker").Pluck("ticker", &stocks)
+	assert.Equal(t, []string{"BRK.B", "N/A"}, stocks)
+	var current StockRating
+	assert.NoError(t, db.Where("ticker = ?", "BRK.B").First(&current).Error)
+	assert.Equal(t, "Buy", current.RatingTo)
+	var revisions, snapshots int64
+	db.Model(&StockRatingRevision{}).Where("ticker = ?", "BRK.B").Count(&revisions)
+	db.Model(&PriceSnapshot{}).Where("ticker = ?", "BRK.B").Count(&snapshots)
+	assert.Equal(t, int64(2), revisions)
+	assert.Equal(t, int64(1), snapshots)
+
+	// the content keys follow the canonical ticker, so the ratings fetched again are not saved twice
+	var keyed StockRatingRevision
+	assert.NoError(t, db.Where("ticker = ? AND brokerage = ?", "BRK.B", "A").First(&keyed).Error)
+	rating.Ticker = "BRK.B"
+	assert.Equal(t, StockRatingContentKey(rating), keyed.RevisionKey)
+}
+
+// TestDeleteStock ensures removing a stock removes everything recorded about it, and ratings can't reference a
+// missing stock
+func TestDeleteStock(t *testing.T) {
+	db := NewTestDB([]StockRating{{Ticker: "AAPL", Brokerage: "A"}, {Ticker: "MSFT", Brokerage: "A"}})
+	db.Create(&StockRatingRevision{RevisionKey: "a", Ticker: "AAPL", Brokerage: "A"})
+	db.Create(&PriceSnapshot{Ticker: "AAPL", LastPrice: 214.65})
+
+	assert.Error(t, db.Create(&StockRating{Ticker: "GOOGL", Brokerage: "A"}).Error)
+
+	assert.NoError(t, DeleteStock(context.Background(), db, "AAPL"))
+	assert.ErrorIs(t, DeleteStock(context.Background(), db, "AAPL"), ErrStockNotFound)
+
+	var ratings, revisions, snapshots int64
+	db.Model(&StockRating{}).Count(&ratings)
+	db.Model(&StockRatingRevision{}).Count(&revisions)
+	db.Model(&PriceSnapshot{}).Count(&snapshots)
+	assert.Equal(t, int64(1), ratings)
+	assert.Equal(t, int64(0), revisions)
+	assert.Equal(t, int64(0), snapshots)
+}
+
+// TestEnsureStock ensures placeholders are only created for missing stocks, and only name the unnamed ones
+func TestEnsureStock(t *testing.T) {
+	db := NewTestDB(nil)
+	db.Create(&Stock{Ticker: "AAPL", Company: "Apple Inc.", LastPrice: 214.65})
+
+	assert.NoError(t, EnsureStock(db, "AAPL", "Apple"))
+	assert.NoError(t, EnsureStock(db, "MSFT", ""))
+	assert.NoError(t, EnsureStock(db, "MSFT", "Microsoft"))
+
+	var stocks []Stock
+	db.Order("ticker").Find(&stocks)
+	assert.Len(t, stocks, 2)
+	assert.Equal(t, "Apple Inc.", stocks[0].Company)
+	assert.Equal(t, 214.65, stocks[0].LastPrice)
+	assert.Equal(t, "Microsoft", stocks[1].Company)
+	assert.Equal(t, DefaultCurrency, stocks[1].Currency)
+}
+
+// TestCheckConsistency ensures the rows of missing stocks are reported, and repaired with placeholder stocks
+func TestCheckConsistency(t *testing.T) {
+	db := NewTestDB([]StockRating{{Ticker: "AAPL", Brokerage: "A"}})
+	db.Create(&StockRatingRevision{RevisionKey: "a", Ticker: "MSFT", Brokerage: "A"})
+	db.Create(&StockRatingRevision{RevisionKey: "b", Ticker: "MSFT", Brokerage: "B"})
+	db.Create(&PriceSnapshot{Ticker: "NVDA"})
+
+	report, err := CheckConsistency(context.Background(), db, false)
+	assert.NoError(t, err)
+	assert.False(t, report.Consistent())
+	assert.Equal(t, []string{"MSFT", "NVDA"}, report.OrphanTickers)
+	assert.Equal(t, int64(0), report.OrphanRatings)
+	assert.Equal(t, int64(2), report.OrphanRevisions)
+	assert.Equal(t, int64(1), report.OrphanSnapshots)
+	assert.False(t, report.Repaired)
+
+	report, err = CheckConsistency(context.Background(), db, true)
+	assert.NoError(t, err)
+	assert.True(t, report.Repaired)
+
+	report, err = CheckConsistency(context.Background(), db, false)
+	assert.NoError(t, err)
+	assert.True(t, report.Consistent())
+}
+
+// TestConnectDB_OrphanRatings ensures connecting leaves the ratings without a stock for the check to report, and only
+// references the stocks once they are repaired
+func TestConnectDB_OrphanRatings(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "orphans.db")+"?_foreign_keys=1"), &gorm.Config{})
+	assert.NoError(t, err)
+
+	// ratings saved before they referenced their stock
+	assert.NoError(t, withoutForeignKeys(db).AutoMigrate(&Stock{}, &StockRating{}))
+	assert.NoError(t, db.Create(&StockRating{Ticker: "AAPL", Brokerage: "A"}).Error)
+
+	assert.NoError(t, ConnectDB("", db))
+	assert.False(t, db.Migrator().HasConstraint(&Stock{}, "Ratings"))
+
+	report, err := CheckConsistency(context.Background(), db, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AAPL"}, report.OrphanTickers)
+	assert.Equal(t, int64(1), report.OrphanRatings)
+
+	report, err = CheckConsistency(context.Background(), db, true)
+	assert.NoError(t, err)
+	assert.True(t, report.Repaired)
+
+	assert.NoError(t, ConnectDB("", db))
+	assert.True(t, db.Migrator().HasConstraint(&Stock{}, "Ratings"))
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"time"
+)
+
+// PriceSnapshot is the quote of a stock at the time it was fetched. A snapshot is appended on every quote fetch, so
+// together they make up the price history of the stock
+type PriceSnapshot struct {
+	ID         uint   `gorm:"primaryKey"`
+	Ticker     string `gorm:"index:idx_price_snapshots_ticker_time"`
+	Open       float64
+	LastClose  float64
+	LastPrice  float64
+	Percentage float64
+	// Currency is the ISO code of the currency of the prices
+	Currency  string    `gorm:"default:'USD'"`
+	FetchedAt time.Time `gorm:"index:idx_price_snapshots_ticker_time"`
+}
+
+// NewPriceSnapshot takes a snapshot of the quote of a stock
+func NewPriceSnapshot(stock Stock, fetchedAt time.Time) PriceSnapshot {
+	return PriceSnapshot{
+		Ticker:     stock.Ticker,
+		Open:       stock.Open,
+		LastClose:  stock.LastClose,
+		LastPrice:  stock.LastPrice,
+		Percentage: stock.Percentage,
+		Currency:   stock.Currency,
+		FetchedAt:  fetchedAt,
+	}
+}
+
+// GetPriceSnapshots retrieves the snapshots of a ticker fetched since the given time, oldest first. A zero time gets
+// the whole history
+func GetPriceSnapshots(ctx context.Context, db *gorm.DB, ticker string, since time.Time) ([]PriceSnapshot, error) {
+	query := db.WithContext(ctx).Where("ticker = ?", ticker)
+	if !since.IsZero() {
+		query = query.Where("fetched_at >= ?", since)
+	}
+
+	var snapshots []PriceSnapshot
+	err := query.Order("fetched_at, id").Find(&snapshots).Error
+	return snapshots, err
+}
+
+// PriceBucket summarizes the last prices of the snapshots fetched within an interval
+type PriceBucket struct {
+	Start   time.Time
+	Open    float64
+	High    float64
+	Low     float64
+	Close   float64
+	Samples int
+}
+
+// BucketPriceSnapshots groups snapshots, oldest first, into the interval each of them starts with. The interval
+// start of a snapshot is given by bucketStart. Empty intervals are left out
+func BucketPriceSnapshots(snapshots []PriceSnapshot, bucketStart func(time.Time) time.Time) []PriceBucket {
+	var buckets []PriceBucket
+	for _, s := range snapshots {
+		start := bucketStart(s.FetchedAt)
+		if n := len(buckets); n > 0 && buckets[n-1].Start.Equal(start) {
+			b := &buckets[n-1]
+			b.High = max(b.High, s.LastPrice)
+			b.Low = min(b.Low, s.LastPrice)
+			b.Close = s.LastPrice
+			b.Samples++
+			continue
+		}
+		buckets = append(buckets, PriceBucket{
+			Start:   start,
+			Open:    s.LastPrice,
+			High:    s.LastPrice,
+			Low:     s.LastPrice,
+			Close:   s.LastPrice,
+			Samples: 1,
+		})
+	}
+	return buckets
+}
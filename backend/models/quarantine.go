@@ -0,0 +1,66 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+const (
+	// RatingFormatApi is the format of the rows of the ratings API, StockRatingRaw
+	RatingFormatApi = "api"
+	// RatingFormatStructured is the format of the rows of the structured ratings API, StructuredRatingRaw
+	RatingFormatStructured = "structured"
+)
+
+// QuarantinedRating is an upstream rating row that couldn't be converted. It is kept as it was received, so it can
+// be replayed once the conversion is fixed. The same row fetched again only refreshes its error and fetch time
+type QuarantinedRating struct {
+	ID uint `gorm:"primaryKey"`
+	// RowKey identifies the row by its content
+	RowKey string `gorm:"uniqueIndex;not null"`
+	// Source is the name of the ratings source the row comes from, and Page the page it was found in
+	Source string `gorm:"index"`
+	Page   string
+	Row    int
+	// Format tells how RawJSON is converted, one of the RatingFormat constants
+	Format    string
+	Ticker    string
+	RawJSON   string `gorm:"type:text"`
+	Error     string
+	FetchedAt time.Time
+}
+
+// QuarantinedRatingKey builds the row key of a quarantined row from its format and raw content
+func QuarantinedRatingKey(format string, rawJson string) string {
+	hash := sha256.Sum256([]byte(format + "\x1f" + rawJson))
+	return "sha256:" + hex.EncodeToString(hash[:])
+}
+
+// SaveQuarantinedRatings quarantines the rows, refreshing the rows already in quarantine
+func SaveQuarantinedRatings(ctx context.Context, db *gorm.DB, rows []QuarantinedRating) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	// a row repeated within the page is quarantined once, as a statement can't upsert the same row twice
+	seen := make(map[string]bool, len(rows))
+	unique := make([]QuarantinedRating, 0, len(rows))
+	for _, row := range rows {
+		if row.RowKey == "" {
+			row.RowKey = QuarantinedRatingKey(row.Format, row.RawJSON)
+		}
+		if !seen[row.RowKey] {
+			seen[row.RowKey] = true
+			unique = append(unique, row)
+		}
+	}
+
+	return db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "row_key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"source", "page", "row", "error", "fetched_at"}),
+	}).Create(&unique).Error
+}
@@ -0,0 +1,130 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"log"
+	"strings"
+)
+
+// RatingActionType is the kind of change a rating makes, parsed from the free text action of the feeds
+type RatingActionType string
+
+// Types of rating actions
+const (
+	ActionInitiation      RatingActionType = "initiation"
+	ActionUpgrade         RatingActionType = "upgrade"
+	ActionDowngrade       RatingActionType = "downgrade"
+	ActionReiteration     RatingActionType = "reiteration"
+	ActionTargetRaise     RatingActionType = "target_raise"
+	ActionTargetCut       RatingActionType = "target_cut"
+	ActionCoverageDropped RatingActionType = "coverage_dropped"
+	// ActionUnknown is the type of the actions whose phrase isn't understood
+	ActionUnknown RatingActionType = "unknown"
+)
+
+// Directions of the rating actions
+const (
+	DirectionUp      = "up"
+	DirectionDown    = "down"
+	DirectionNeutral = "neutral"
+)
+
+// RatingActionTypes lists every type of rating action
+var RatingActionTypes = []RatingActionType{ActionInitiation, ActionUpgrade, ActionDowngrade, ActionReiteration,
+	ActionTargetRaise, ActionTargetCut, ActionCoverageDropped, ActionUnknown}
+
+// Direction tells whether the action is bullish (up), bearish (down) or neither
+func (t RatingActionType) Direction() string {
+	switch t {
+	case ActionUpgrade, ActionTargetRaise:
+		return DirectionUp
+	case ActionDowngrade, ActionTargetCut:
+		return DirectionDown
+	default:
+		return DirectionNeutral
+	}
+}
+
+// ratingActionPhrases maps the words found in the action phrases to their type. They are checked in order, so
+// "target raised" is a raise rather than a reiteration of the target
+var ratingActionPhrases = []struct {
+	word       string
+	actionType RatingActionType
+}{
+	{"upgrade", ActionUpgrade},
+	{"downgrade", ActionDowngrade},
+	{"initiat", ActionInitiation},
+	{"resume", ActionInitiation},
+	{"assumed", ActionInitiation},
+	{"drop", ActionCoverageDropped},
+	{"discontinu", ActionCoverageDropped},
+	{"terminat", ActionCoverageDropped},
+	{"suspend", ActionCoverageDropped},
+	{"raise", ActionTargetRaise},
+	{"boost", ActionTargetRaise},
+	{"increase", ActionTargetRaise},
+	{"lower", ActionTargetCut},
+	{"cut", ActionTargetCut},
+	{"reduce", ActionTargetCut},
+	{"decrease", ActionTargetCut},
+	{"reiterat", ActionReiteration},
+	{"maintain", ActionReiteration},
+	{"affirm", ActionReiteration},
+}
+
+// ClassifyRatingAction parses an action phrase, such as "target raised by", into its type. Phrases that only say the
+// target was set or changed are typed by comparing the targets. Tells whether the phrase was understood
+func ClassifyRatingAction(action string, targetFrom float64, targetTo float64) (RatingActionType, bool) {
+	phrase := strings.ToLower(action)
+	for _, p := range ratingActionPhrases {
+		if strings.Contains(phrase, p.word) {
+			return p.actionType, true
+		}
+	}
+
+	if strings.Contains(phrase, "target") {
+		switch {
+		case targetTo > targetFrom:
+			return ActionTargetRaise, true
+		case targetTo < targetFrom:
+			return ActionTargetCut, true
+		default:
+			return ActionReiteration, true
+		}
+	}
+	return ActionUnknown, false
+}
+
+// BackfillRatingActionTypes types the ratings saved before their actions were typed
+func BackfillRatingActionTypes(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		typed := 0
+		for _, table := range []any{&StockRatingRevision{}, &StockRating{}} {
+			var rows []StockRatingRevision
+			err := tx.Model(table).
+				Select("action", "target_from", "target_to").
+				Where("action_type = '' OR action_type IS NULL").
+				Distinct().
+				Find(&rows).Error
+			if err != nil {
+				return err
+			}
+
+			for _, row := range rows {
+				actionType, _ := ClassifyRatingAction(row.Action, row.TargetFrom, row.TargetTo)
+				err := tx.Model(table).
+					Where("action = ? AND target_from = ? AND target_to = ?", row.Action, row.TargetFrom, row.TargetTo).
+					Where("action_type = '' OR action_type IS NULL").
+					Update("action_type", actionType).Error
+				if err != nil {
+					return err
+				}
+				typed++
+			}
+		}
+		if typed > 0 {
+			log.Printf("Typed the actions of %d kinds of ratings", typed)
+		}
+		return nil
+	})
+}
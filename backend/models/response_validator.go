@@ -0,0 +1,39 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+// ResponseValidator keeps the validators of the last response saved from an upstream URL, so the next request can
+// be made conditional on the response having changed
+type ResponseValidator struct {
+	URL          string `gorm:"primaryKey"`
+	ETag         string
+	LastModified string
+	// NextPage is the cursor of the next page given by the response, as a 304 response has no body to read it from
+	NextPage  string
+	UpdatedAt time.Time
+}
+
+// GetResponseValidator retrieves the validators of a URL. A URL never saved gets empty validators
+func GetResponseValidator(ctx context.Context, db *gorm.DB, url string) (ResponseValidator, error) {
+	var validator ResponseValidator
+	err := db.WithContext(ctx).Where("url = ?", url).First(&validator).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ResponseValidator{URL: url}, nil
+	}
+	return validator, err
+}
+
+// SaveResponseValidator creates or updates the validators of a URL
+func SaveResponseValidator(ctx context.Context, db *gorm.DB, validator ResponseValidator) error {
+	return db.WithContext(ctx).Save(&validator).Error
+}
+
+// ClearResponseValidators forgets every validator, so the next requests download the full responses again
+func ClearResponseValidators(ctx context.Context, db *gorm.DB) error {
+	return db.WithContext(ctx).Where("1 = 1").Delete(&ResponseValidator{}).Error
+}
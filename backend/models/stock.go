@@ -0,0 +1,48 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrStockNotFound is returned when removing a stock that doesn't exist
+var ErrStockNotFound = errors.New("stock not found")
+
+// EnsureStock creates a placeholder stock for a ticker rated before its info was fetched, so its ratings reference
+// a stock. The placeholder is named after the given company, if any, and is filled in by the next info fetch.
+// Existing stocks are left alone, unless they have no company yet
+func EnsureStock(db *gorm.DB, ticker string, company string) error {
+	placeholder := Stock{Ticker: ticker, Company: company}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&placeholder).Error; err != nil {
+		return err
+	}
+	if company == "" {
+		return nil
+	}
+	return db.Model(&Stock{}).Where("ticker = ? AND company = ?", ticker, "").Update("company", company).Error
+}
+
+// DeleteStock removes a stock along with everything recorded about it: its current ratings, which cascade from the
+// stock, its rating history and its price history
+func DeleteStock(ctx context.Context, db *gorm.DB, ticker string) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("ticker = ?", ticker).Delete(&Stock{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrStockNotFound
+		}
+
+		// the cascade is repeated for the databases that don't enforce foreign keys
+		if err := tx.Where("ticker = ?", ticker).Delete(&StockRating{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("ticker = ?", ticker).Delete(&StockRatingRevision{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("ticker = ?", ticker).Delete(&PriceSnapshot{}).Error
+	})
+}
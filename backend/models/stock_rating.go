@@ -1,25 +1,140 @@
 package models
 
-import "time"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
 
 // Stock represents an observed stock and its static information
 type Stock struct {
-	Ticker         string `gorm:"primaryKey"`
-	LastPrice      float64
+	Ticker    string `gorm:"primaryKey"`
+	LastPrice float64
+	// Open, LastClose and Percentage complete the latest quote along with LastPrice
+	Open       float64
+	LastClose  float64
+	Percentage float64
+	// Currency is the ISO code of the currency of LastPrice
+	Currency       string `gorm:"default:'USD'"`
 	Company        string
 	Recommendation string
+	// Ratings are the current ratings of the stock, which are removed along with it
+	Ratings []StockRating `gorm:"foreignKey:Ticker;references:Ticker;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
 }
 
-// StockRating represents the most recent stock rating given by some broker
+// StockRating represents the most recent stock rating given by some broker. It is derived from the broker's
+// latest StockRatingRevision
 type StockRating struct {
-	Ticker     string `gorm:"primaryKey"` // FIXME: add foreign key
+	Ticker     string `gorm:"primaryKey"`
 	Brokerage  string `gorm:"primaryKey"`
 	TargetFrom float64
 	TargetTo   float64
 	Action     string
+	// ActionType is the type of Action, parsed from its phrase
+	ActionType RatingActionType `gorm:"index"`
 	RatingFrom string
 	RatingTo   string
 	Time       time.Time
+	// Currency is the ISO code of the currency of the targets
+	Currency string `gorm:"default:'USD'"`
+	// RevisionKey identifies the revision the rating comes from
+	RevisionKey string
+	// Company is the name of the rated company given along with the rating, if any. It isn't saved, it only names
+	// the placeholder stock created when the rating arrives before the info of its stock
+	Company string `gorm:"-"`
+	// UpstreamID is the ID the upstream gave the rating, if any. It isn't saved, it keys the revision once namespaced
+	// by the source the rating comes from
+	UpstreamID string `gorm:"-"`
+}
+
+// StockRatingRevision represents a stock rating given by some broker at some point in time. Revisions are only
+// appended, so together they hold the whole rating history of a broker for a stock. RevisionKey is the natural key
+// of a revision, which is used to deduplicate the ratings fetched more than once
+type StockRatingRevision struct {
+	ID          uint   `gorm:"primaryKey"`
+	RevisionKey string `gorm:"uniqueIndex;not null"`
+	Ticker      string `gorm:"index:idx_revision_ticker_brokerage"`
+	Brokerage   string `gorm:"index:idx_revision_ticker_brokerage"`
+	TargetFrom  float64
+	TargetTo    float64
+	Action      string
+	ActionType  RatingActionType
+	RatingFrom  string
+	RatingTo    string
+	Time        time.Time
+	Currency    string `gorm:"default:'USD'"`
+	CreatedAt   time.Time
+}
+
+// NewStockRatingRevision creates the revision recording the given rating. Ratings without a revision key are keyed
+// by their content
+func NewStockRatingRevision(rating StockRating) StockRatingRevision {
+	key := rating.RevisionKey
+	if key == "" {
+		key = StockRatingContentKey(rating)
+	}
+
+	return StockRatingRevision{
+		RevisionKey: key,
+		Ticker:      rating.Ticker,
+		Brokerage:   rating.Brokerage,
+		TargetFrom:  rating.TargetFrom,
+		TargetTo:    rating.TargetTo,
+		Action:      rating.Action,
+		ActionType:  rating.ActionType,
+		RatingFrom:  rating.RatingFrom,
+		RatingTo:    rating.RatingTo,
+		Time:        rating.Time,
+		Currency:    rating.Currency,
+	}
+}
+
+// StockRatingUpstreamKey builds the revision key of a rating the upstream gave an ID to. The key is namespaced by the
+// source the rating comes from, as IDs are only unique within it
+func StockRatingUpstreamKey(upstream string, id string) string {
+	return "id:" + upstream + ":" + id
+}
+
+// StockRatingContentKey builds the revision key of a rating from its content, for ratings without an upstream ID.
+// The same rating fetched twice gets the same key, while two brokerages rating at the same instant don't
+func StockRatingContentKey(rating StockRating) string {
+	fields := []string{
+		rating.Ticker,
+		rating.Brokerage,
+		rating.Time.UTC().Format(time.RFC3339Nano),
+		rating.Action,
+		rating.RatingFrom,
+		rating.RatingTo,
+		fmt.Sprintf("%g", rating.TargetFrom),
+		fmt.Sprintf("%g", rating.TargetTo),
+	}
+	// dollar ratings keep the keys they had before currencies were recorded
+	if rating.Currency != "" && rating.Currency != DefaultCurrency {
+		fields = append(fields, rating.Currency)
+	}
+	content := strings.Join(fields, "\x1f")
+
+	hash := sha256.Sum256([]byte(content))
+	return "sha256:" + hex.EncodeToString(hash[:])
+}
+
+// Rating gets the StockRating recorded by the revision
+func (r StockRatingRevision) Rating() StockRating {
+	return StockRating{
+		Ticker:      r.Ticker,
+		Brokerage:   r.Brokerage,
+		TargetFrom:  r.TargetFrom,
+		TargetTo:    r.TargetTo,
+		Action:      r.Action,
+		ActionType:  r.ActionType,
+		RatingFrom:  r.RatingFrom,
+		RatingTo:    r.RatingTo,
+		Time:        r.Time,
+		Currency:    r.Currency,
+		RevisionKey: r.RevisionKey,
+	}
 }
 
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -28,6 +143,7 @@ type StockRating struct {
 
 // StockRatingRaw matches the raw stock structure in a response
 type StockRatingRaw struct {
+	ID         string `json:"id,omitempty"`
 	Ticker     string `json:"ticker"`
 	TargetFrom string `json:"target_from"`
 	TargetTo   string `json:"target_to"`
@@ -62,3 +178,40 @@ type StockInfoRaw struct {
 
 // StockInfoQueryResponse represents the API response containing multiple stocks.
 type StockInfoQueryResponse []StockInfoRaw
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///			STRUCTURED RATINGS API MODELS
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// StructuredRatingRaw matches a rating of the structured ratings API, which gives numeric targets
+type StructuredRatingRaw struct {
+	ID          string                 `json:"id"`
+	Symbol      string                 `json:"symbol"`
+	Firm        string                 `json:"firm"`
+	Action      string                 `json:"action"`
+	Rating      StructuredRatingChange `json:"rating"`
+	PriceTarget StructuredTargetChange `json:"price_target"`
+	PublishedAt string                 `json:"published_at"`
+}
+
+// StructuredRatingChange matches the rating change of a structured rating
+type StructuredRatingChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// StructuredTargetChange matches the price target change of a structured rating
+type StructuredTargetChange struct {
+	From     float64 `json:"from"`
+	To       float64 `json:"to"`
+	Currency string  `json:"currency,omitempty"`
+}
+
+// StructuredRatingsResponse matches the JSON response of the structured ratings API, paginated by offset
+type StructuredRatingsResponse struct {
+	Data []StructuredRatingRaw `json:"data"`
+	Meta struct {
+		Offset int `json:"offset"`
+		Total  int `json:"total"`
+	} `json:"meta"`
+}
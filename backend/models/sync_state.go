@@ -0,0 +1,33 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+// RatingsSyncState keeps track of how far the ratings feed of a source has been processed
+type RatingsSyncState struct {
+	Source string `gorm:"primaryKey"`
+	// Cursor is the page the next incremental run resumes from
+	Cursor string
+	// HighWaterMark is the time of the newest rating seen so far
+	HighWaterMark time.Time
+	UpdatedAt     time.Time
+}
+
+// GetRatingsSyncState retrieves the sync state of a ratings source. A source that was never synced gets an empty state
+func GetRatingsSyncState(ctx context.Context, db *gorm.DB, source string) (RatingsSyncState, error) {
+	state := RatingsSyncState{Source: source}
+	err := db.WithContext(ctx).Where("source = ?", source).First(&state).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return RatingsSyncState{Source: source}, nil
+	}
+	return state, err
+}
+
+// SaveRatingsSyncState creates or updates the sync state of a ratings source
+func SaveRatingsSyncState(ctx context.Context, db *gorm.DB, state RatingsSyncState) error {
+	return db.WithContext(ctx).Save(&state).Error
+}
@@ -0,0 +1,175 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidTicker is returned for symbols that aren't valid tickers
+var ErrInvalidTicker = errors.New("invalid ticker")
+
+// TickerClassSeparator separates the share class of the canonical tickers, as in "BRK.B"
+const TickerClassSeparator = "."
+
+// tickerPattern matches the canonical tickers: a symbol of up to six letters or digits, starting with a letter, and an
+// optional share class
+var tickerPattern = regexp.MustCompile(`^[A-Z][A-Z0-9]{0,5}(\.[A-Z0-9]{1,3})?$`)
+
+// junkTickers are placeholders the feeds give instead of a ticker
+var junkTickers = map[string]bool{"N/A": true, "NA": true, "NONE": true, "NULL": true, "NIL": true, "TBD": true,
+	"UNKNOWN": true, "TEST": true}
+
+// NormalizeTicker converts a symbol to its canonical ticker: trimmed, upper case, and with its share class separated by
+// a dot whichever separator the vendor uses ("brk-b", "BRK/B" and "BRK B" all give "BRK.B"). Junk symbols and symbols
+// that don't look like tickers are rejected with ErrInvalidTicker
+func NormalizeTicker(symbol string) (string, error) {
+	ticker := strings.ToUpper(strings.TrimSpace(symbol))
+	if junkTickers[ticker] {
+		return "", fmt.Errorf("%w %q", ErrInvalidTicker, symbol)
+	}
+
+	ticker = strings.NewReplacer("-", TickerClassSeparator, "/", TickerClassSeparator, " ", TickerClassSeparator).Replace(ticker)
+	if !tickerPattern.MatchString(ticker) {
+		return "", fmt.Errorf("%w %q", ErrInvalidTicker, symbol)
+	}
+	return ticker, nil
+}
+
+// VendorTicker converts a canonical ticker to the convention of a vendor, which separates share classes with the given
+// separator. An empty separator keeps the canonical dot
+func VendorTicker(ticker string, separator string) string {
+	if separator == "" {
+		return ticker
+	}
+	return strings.ReplaceAll(ticker, TickerClassSeparator, separator)
+}
+
+// NormalizeStoredTickers converts the tickers saved before they were normalized to their canonical form. The rating
+// history, price history and stocks are re-keyed, merging into the canonical ticker when both were saved. Invalid
+// tickers are left alone
+func NormalizeStoredTickers(db *gorm.DB) error {
+	var stored []string
+	for _, table := range []any{&StockRatingRevision{}, &StockRating{}, &Stock{}} {
+		var tickers []string
+		if err := db.Model(table).Distinct().Pluck("ticker", &tickers).Error; err != nil {
+			return err
+		}
+		stored = append(stored, tickers...)
+	}
+
+	renames := make(map[string]string)
+	for _, ticker := range stored {
+		canonical, err := NormalizeTicker(ticker)
+		if err == nil && canonical != ticker {
+			renames[ticker] = canonical
+		}
+	}
+	if len(renames) == 0 {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for from, to := range renames {
+			if err := rekeyTicker(tx, from, to); err != nil {
+				return fmt.Errorf("failed to re-key %s to %s: %w", from, to, err)
+			}
+			log.Printf("Re-keyed the ticker %q to %q", from, to)
+		}
+		return nil
+	})
+}
+
+// rekeyRevisions moves the rating history of a ticker to another one, and gets the brokerages it holds. Revisions keyed
+// by their content get the key of their new content, so the same rating fetched again under the canonical ticker
+// isn't taken for a new one. A revision the canonical ticker already has is dropped
+func rekeyRevisions(tx *gorm.DB, from string, to string) ([]string, error) {
+	var revisions []StockRatingRevision
+	if err := tx.Where("ticker = ?", from).Order("id").Find(&revisions).Error; err != nil {
+		return nil, err
+	}
+
+	var brokerages []string
+	for _, revision := range revisions {
+		if !slices.Contains(brokerages, revision.Brokerage) {
+			brokerages = append(brokerages, revision.Brokerage)
+		}
+
+		revision.Ticker = to
+		if strings.HasPrefix(revision.RevisionKey, "sha256:") {
+			revision.RevisionKey = StockRatingContentKey(revision.Rating())
+		}
+
+		var duplicates int64
+		if err := tx.Model(&StockRatingRevision{}).Where("revision_key = ? AND id <> ?", revision.RevisionKey, revision.ID).Count(&duplicates).Error; err != nil {
+			return nil, err
+		}
+		if duplicates > 0 {
+			if err := tx.Delete(&StockRatingRevision{}, revision.ID).Error; err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		err := tx.Model(&StockRatingRevision{}).Where("id = ?", revision.ID).
+			Updates(map[string]any{"ticker": revision.Ticker, "revision_key": revision.RevisionKey}).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return brokerages, nil
+}
+
+// rekeyTicker moves everything saved under a ticker to another one, deriving the current ratings again
+func rekeyTicker(tx *gorm.DB, from string, to string) error {
+	// the stock is moved first, as the current ratings reference it
+	var stock Stock
+	err := tx.Where("ticker = ?", from).First(&stock).Error
+	switch {
+	case err == nil:
+		stock.Ticker = to
+		if err := tx.Where("ticker = ?", to).FirstOrCreate(&stock).Error; err != nil {
+			return err
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if err := EnsureStock(tx, to, ""); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	brokerages, err := rekeyRevisions(tx, from, to)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(&PriceSnapshot{}).Where("ticker = ?", from).Update("ticker", to).Error; err != nil {
+		return err
+	}
+
+	// current ratings without a history are carried over as they are, unless the canonical ticker has its own
+	var current []StockRating
+	if err := tx.Where("ticker = ?", from).Find(&current).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("ticker = ?", from).Delete(&StockRating{}).Error; err != nil {
+		return err
+	}
+	for _, rating := range current {
+		rating.Ticker = to
+		if err := tx.Where("ticker = ? AND brokerage = ?", to, rating.Brokerage).FirstOrCreate(&rating).Error; err != nil {
+			return err
+		}
+	}
+	for _, brokerage := range brokerages {
+		if err := RefreshCurrentRating(tx, to, brokerage); err != nil {
+			return err
+		}
+	}
+
+	return tx.Where("ticker = ?", from).Delete(&Stock{}).Error
+}
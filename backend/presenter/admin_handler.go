@@ -0,0 +1,105 @@
+package presenter
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/c4ts0up/my-stocks/backend/fetcher"
+	"github.com/c4ts0up/my-stocks/backend/models"
+	presenter "github.com/c4ts0up/my-stocks/backend/presenter/models"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RequireAdminToken guards the admin endpoints, which must be called with the given bearer token
+func RequireAdminToken(token string) gin.HandlerFunc {
+	expected := []byte("Bearer " + token)
+	return func(c *gin.Context) {
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), expected) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// GetQuarantinedRatings handles GET /admin/quarantine
+func GetQuarantinedRatings(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+		return
+	}
+
+	query := models.DB.Model(&models.QuarantinedRating{})
+	if source := c.Query("source"); source != "" {
+		query = query.Where("source = ?", source)
+	}
+
+	var total int64
+	if result := query.Count(&total); result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch quarantined ratings"})
+		return
+	}
+
+	var rows []models.QuarantinedRating
+	if result := query.Order("id").Limit(limit).Offset(offset).Find(&rows); result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch quarantined ratings"})
+		return
+	}
+
+	response := presenter.QuarantinedRatingList{
+		Total: total,
+		Rows:  make([]presenter.QuarantinedRating, len(rows)),
+	}
+	for i, r := range rows {
+		response.Rows[i] = presenter.QuarantinedRating{
+			ID:        r.ID,
+			Source:    r.Source,
+			Page:      r.Page,
+			Row:       r.Row,
+			Format:    r.Format,
+			Ticker:    r.Ticker,
+			Raw:       json.RawMessage(r.RawJSON),
+			Error:     r.Error,
+			FetchedAt: r.FetchedAt.Format(time.RFC3339Nano),
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ReplayQuarantinedRatings handles POST /admin/quarantine/replay. Replays the rows with the given IDs, or every
+// quarantined row when none are given
+func ReplayQuarantinedRatings(c *gin.Context) {
+	var request presenter.ReplayRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid replay request"})
+			return
+		}
+	}
+
+	ratingsFetcher := fetcher.BasicStockRatingsFetcher{DB: models.DB}
+	results, err := ratingsFetcher.ReplayQuarantined(c.Request.Context(), request.IDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to replay quarantined ratings"})
+		return
+	}
+
+	response := make([]presenter.ReplayResult, len(results))
+	for i, r := range results {
+		response[i] = presenter.ReplayResult{ID: r.ID, Ticker: r.Ticker, Replayed: r.Replayed}
+		if r.Err != nil {
+			response[i].Error = r.Err.Error()
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,75 @@
+package presenter
+
+import (
+	"errors"
+	"github.com/c4ts0up/my-stocks/backend/jobs"
+	"github.com/c4ts0up/my-stocks/backend/models"
+	presenter "github.com/c4ts0up/my-stocks/backend/presenter/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+// GetBrokerages handles GET /admin/brokerages, listing every brokerage along with its aliases
+func GetBrokerages(c *gin.Context) {
+	var brokerages []models.Brokerage
+	if result := models.DB.Order("name").Find(&brokerages); result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch brokerages"})
+		return
+	}
+	var aliases []models.BrokerageAlias
+	if result := models.DB.Order("alias").Find(&aliases); result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch brokerages"})
+		return
+	}
+
+	aliasesOf := make(map[uint][]string)
+	for _, a := range aliases {
+		aliasesOf[a.BrokerageID] = append(aliasesOf[a.BrokerageID], a.Alias)
+	}
+
+	response := make([]presenter.Brokerage, len(brokerages))
+	for i, b := range brokerages {
+		response[i] = presenter.Brokerage{ID: b.ID, Name: b.Name, Aliases: aliasesOf[b.ID]}
+		if response[i].Aliases == nil {
+			response[i].Aliases = []string{}
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// MergeBrokerages handles POST /admin/brokerages/merge. The source brokerage becomes an alias of the target one, and
+// its ratings are re-keyed to it. The recommendations are analyzed again, as the merged votes count once
+func MergeBrokerages(c *gin.Context) {
+	var request presenter.BrokerageMergeRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.SourceID == 0 || request.TargetID == 0 || request.SourceID == request.TargetID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid merge request"})
+		return
+	}
+
+	merge, err := models.MergeBrokerages(c.Request.Context(), models.DB, request.SourceID, request.TargetID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "brokerage not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to merge brokerages"})
+		return
+	}
+
+	response := presenter.BrokerageMergeResult{
+		ID:        merge.Into.ID,
+		Name:      merge.Into.Name,
+		Revisions: merge.Revisions,
+		Tickers:   merge.Tickers,
+	}
+	if response.Tickers == nil {
+		response.Tickers = []string{}
+	}
+	if len(merge.Tickers) > 0 && Jobs != nil {
+		response.AnalysisJobID = Jobs.QueueAnalysis(jobs.TriggerManual, "").ID
+	}
+
+	c.JSON(http.StatusOK, response)
+}
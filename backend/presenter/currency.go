@@ -0,0 +1,28 @@
+package presenter
+
+import (
+	"github.com/c4ts0up/my-stocks/backend/models"
+	"github.com/gin-gonic/gin"
+	"log"
+)
+
+// ReportingCurrency is the currency prices and targets are also given in, so they can be compared
+var ReportingCurrency = models.DefaultCurrency
+
+// loadFxRates gets the FX rate table. On failure only amounts already in the reporting currency are reported in it
+func loadFxRates(c *gin.Context) models.FxRates {
+	rates, err := models.GetFxRates(c.Request.Context(), models.DB)
+	if err != nil {
+		log.Printf("Failed to load the FX rates: %v", err)
+	}
+	return rates
+}
+
+// reportingAmount converts an amount to the reporting currency. Nil when there is no FX rate for its currency
+func reportingAmount(rates models.FxRates, amount float64, currency string) *float64 {
+	converted, err := rates.Convert(amount, currency, ReportingCurrency)
+	if err != nil {
+		return nil
+	}
+	return &converted
+}
@@ -0,0 +1,103 @@
+package presenter
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/c4ts0up/my-stocks/backend/models"
+	presenter "github.com/c4ts0up/my-stocks/backend/presenter/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// GetFetchRuns handles GET /admin/fetch-runs, listing the newest runs first
+func GetFetchRuns(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+		return
+	}
+
+	query := models.DB.Model(&models.FetchRun{})
+	if outcome := c.Query("outcome"); outcome != "" {
+		query = query.Where("outcome = ?", outcome)
+	}
+
+	var total int64
+	if result := query.Count(&total); result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch the fetch runs"})
+		return
+	}
+
+	var runs []models.FetchRun
+	if result := query.Order("started_at desc, id desc").Limit(limit).Offset(offset).Find(&runs); result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch the fetch runs"})
+		return
+	}
+
+	response := presenter.FetchRunList{
+		Total: total,
+		Runs:  make([]presenter.FetchRun, len(runs)),
+	}
+	for i, r := range runs {
+		response.Runs[i] = toFetchRun(r)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetFetchRun handles GET /admin/fetch-runs/:id
+func GetFetchRun(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid fetch run id"})
+		return
+	}
+
+	var run models.FetchRun
+	if result := models.DB.First(&run, id); result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "fetch run not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch the fetch run"})
+		return
+	}
+
+	c.JSON(http.StatusOK, toFetchRun(run))
+}
+
+// toFetchRun converts a FetchRun model to its presentation
+func toFetchRun(r models.FetchRun) presenter.FetchRun {
+	run := presenter.FetchRun{
+		ID:               r.ID,
+		StartedAt:        r.StartedAt.Format(time.RFC3339Nano),
+		Outcome:          r.Outcome,
+		PagesFetched:     r.PagesFetched,
+		PagesUnchanged:   r.PagesUnchanged,
+		PagesFailed:      r.PagesFailed,
+		RatingsSaved:     r.RatingsSaved,
+		RatingsSkipped:   r.RatingsSkipped,
+		TickersRefreshed: r.TickersRefreshed,
+		TickersUnchanged: r.TickersUnchanged,
+		TickersSkipped:   r.TickersSkipped,
+		TickersFailed:    r.TickersFailed,
+		Error:            r.Error,
+		Problems:         []string{},
+		ProblemCount:     r.ProblemCount,
+	}
+	if r.FinishedAt != nil {
+		run.FinishedAt = r.FinishedAt.Format(time.RFC3339Nano)
+	}
+	if r.ProblemsJSON != "" {
+		_ = json.Unmarshal([]byte(r.ProblemsJSON), &run.Problems)
+	}
+	return run
+}
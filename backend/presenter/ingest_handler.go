@@ -0,0 +1,111 @@
+package presenter
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/c4ts0up/my-stocks/backend/fetcher"
+	"github.com/c4ts0up/my-stocks/backend/jobs"
+	"github.com/c4ts0up/my-stocks/backend/models"
+	presenter "github.com/c4ts0up/my-stocks/backend/presenter/models"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// SignatureHeader carries the HMAC-SHA256 signature of the pushed body, as sha256=<hex digest>
+const SignatureHeader = "X-Signature"
+
+// VendorHeader names the vendor pushing the batch. The IDs of the pushed ratings are namespaced by it, so vendors
+// sharing an ID don't overwrite each other
+const VendorHeader = "X-Vendor"
+
+// vendorPattern matches the accepted vendor names
+var vendorPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// maxIngestBodyBytes bounds the size of a pushed batch
+const maxIngestBodyBytes = 10 << 20
+
+// maxIngestRows bounds the rows of a pushed batch
+const maxIngestRows = 1000
+
+// RequireSignature guards the ingestion endpoints, whose bodies must be signed with the given secret
+func RequireSignature(secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxIngestBodyBytes))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "batch too large"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to read the batch"})
+			return
+		}
+
+		signature, ok := strings.CutPrefix(c.GetHeader(SignatureHeader), "sha256=")
+		received, err := hex.DecodeString(signature)
+		if !ok || err != nil || !hmac.Equal(received, Sign(secret, body)) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid signature"})
+			return
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
+
+// Sign computes the HMAC-SHA256 of a body with the given secret
+func Sign(secret string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// IngestRatings handles POST /ingest/ratings. Saves the pushed rating rows like fetched ones, and queues an info
+// refresh of the tickers that got new ratings
+func IngestRatings(c *gin.Context) {
+	var rows []json.RawMessage
+	if err := c.ShouldBindJSON(&rows); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "the batch must be a JSON array of ratings"})
+		return
+	}
+	if len(rows) > maxIngestRows {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "batch too large"})
+		return
+	}
+
+	vendor := c.GetHeader(VendorHeader)
+	if vendor != "" && !vendorPattern.MatchString(vendor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid vendor"})
+		return
+	}
+
+	ratingsFetcher := fetcher.BasicStockRatingsFetcher{DB: models.DB}
+	results, tickers, err := ratingsFetcher.IngestRatings(c.Request.Context(), vendor, rows)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save the ratings"})
+		return
+	}
+
+	response := presenter.IngestResponse{Rows: make([]presenter.IngestResult, len(results))}
+	for i, r := range results {
+		response.Rows[i] = presenter.IngestResult{Row: r.Row, Ticker: r.Ticker, Accepted: r.Accepted, Duplicate: r.Duplicate}
+		if r.Accepted {
+			response.Accepted++
+		} else {
+			response.Rejected++
+			response.Rows[i].Error = r.Err.Error()
+		}
+	}
+	if len(tickers) > 0 && Jobs != nil {
+		response.RefreshJobID = Jobs.QueueFetch(jobs.TriggerIngest, tickers).ID
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,108 @@
+package presenter
+
+import (
+	"github.com/c4ts0up/my-stocks/backend/jobs"
+	"github.com/c4ts0up/my-stocks/backend/models"
+	presenter "github.com/c4ts0up/my-stocks/backend/presenter/models"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Jobs queues the fetch and analysis jobs triggered through the admin endpoints
+var Jobs *jobs.Service
+
+// QueueFetchJob handles POST /admin/jobs/fetch. Queues a fetch of the given tickers, or of everything when none are
+// given
+func QueueFetchJob(c *gin.Context) {
+	var request presenter.FetchJobRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid fetch job request"})
+			return
+		}
+	}
+
+	var tickers []string
+	for _, ticker := range request.Tickers {
+		if ticker = strings.TrimSpace(ticker); ticker == "" {
+			continue
+		}
+		canonical, err := models.NormalizeTicker(ticker)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ticker " + ticker})
+			return
+		}
+		tickers = append(tickers, canonical)
+	}
+	if len(request.Tickers) > 0 && len(tickers) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid fetch job request"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, toJob(Jobs.QueueFetch(jobs.TriggerManual, tickers)))
+}
+
+// QueueAnalysisJob handles POST /admin/jobs/analysis. Queues an analysis of the given ticker, or of every stock when
+// none is given
+func QueueAnalysisJob(c *gin.Context) {
+	var request presenter.AnalysisJobRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid analysis job request"})
+			return
+		}
+	}
+
+	ticker := strings.TrimSpace(request.Ticker)
+	if ticker != "" {
+		var err error
+		if ticker, err = models.NormalizeTicker(ticker); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ticker"})
+			return
+		}
+
+		var stock models.Stock
+		if result := models.DB.Where("ticker = ?", ticker).First(&stock); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "stock not found"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusAccepted, toJob(Jobs.QueueAnalysis(jobs.TriggerManual, ticker)))
+}
+
+// GetJob handles GET /admin/jobs/:id
+func GetJob(c *gin.Context) {
+	job, ok := Jobs.Runner.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, toJob(job))
+}
+
+// toJob converts a job to its presentation
+func toJob(j jobs.Job) presenter.Job {
+	job := presenter.Job{
+		ID:       j.ID,
+		Kind:     j.Kind,
+		Trigger:  j.Trigger,
+		Tickers:  j.Tickers,
+		Status:   j.Status,
+		Result:   j.Result,
+		Error:    j.Error,
+		QueuedAt: j.QueuedAt.Format(time.RFC3339Nano),
+	}
+	if j.Stage != "" {
+		job.Progress = &presenter.JobProgress{Stage: j.Stage, Done: j.Done, Total: j.Total}
+	}
+	if j.StartedAt != nil {
+		job.StartedAt = j.StartedAt.Format(time.RFC3339Nano)
+	}
+	if j.FinishedAt != nil {
+		job.FinishedAt = j.FinishedAt.Format(time.RFC3339Nano)
+	}
+	return job
+}
@@ -0,0 +1,119 @@
+package presenter
+
+import "encoding/json"
+
+// QuarantinedRating shows an upstream rating row that couldn't be converted, as it was received
+type QuarantinedRating struct {
+	ID        uint            `json:"id"`
+	Source    string          `json:"source"`
+	Page      string          `json:"page"`
+	Row       int             `json:"row"`
+	Format    string          `json:"format"`
+	Ticker    string          `json:"ticker"`
+	Raw       json.RawMessage `json:"raw"`
+	Error     string          `json:"error"`
+	FetchedAt string          `json:"fetched_at"`
+}
+
+// QuarantinedRatingList gives a page of the quarantined rows, along with the total amount of rows
+type QuarantinedRatingList struct {
+	Total int64               `json:"total"`
+	Rows  []QuarantinedRating `json:"rows"`
+}
+
+// ReplayRequest lists the quarantined rows to replay. Every row is replayed when empty
+type ReplayRequest struct {
+	IDs []uint `json:"ids"`
+}
+
+// ReplayResult shows whether a quarantined row could be replayed
+type ReplayResult struct {
+	ID       uint   `json:"id"`
+	Ticker   string `json:"ticker"`
+	Replayed bool   `json:"replayed"`
+	Error    string `json:"error,omitempty"`
+}
+
+// FetchRun shows a run of the fetcher, from its start to its outcome
+type FetchRun struct {
+	ID uint `json:"id"`
+	// Outcome is running, succeeded, partial or failed
+	Outcome          string `json:"outcome"`
+	StartedAt        string `json:"started_at"`
+	FinishedAt       string `json:"finished_at,omitempty"`
+	PagesFetched     int    `json:"pages_fetched"`
+	PagesUnchanged   int    `json:"pages_unchanged"`
+	PagesFailed      int    `json:"pages_failed"`
+	RatingsSaved     int    `json:"ratings_saved"`
+	RatingsSkipped   int    `json:"ratings_skipped"`
+	TickersRefreshed int    `json:"tickers_refreshed"`
+	TickersUnchanged int    `json:"tickers_unchanged"`
+	TickersSkipped   int    `json:"tickers_skipped"`
+	TickersFailed    int    `json:"tickers_failed"`
+	Error            string `json:"error,omitempty"`
+	// Problems lists the first failed or skipped items of the run, out of ProblemCount
+	Problems     []string `json:"problems"`
+	ProblemCount int      `json:"problem_count"`
+}
+
+// FetchRunList gives a page of the fetch runs, along with the total amount of runs
+type FetchRunList struct {
+	Total int64      `json:"total"`
+	Runs  []FetchRun `json:"runs"`
+}
+
+// FetchJobRequest lists the tickers whose info to fetch. Everything is fetched when empty
+type FetchJobRequest struct {
+	Tickers []string `json:"tickers"`
+}
+
+// AnalysisJobRequest gives the ticker to analyze. Every stock is analyzed when empty
+type AnalysisJobRequest struct {
+	Ticker string `json:"ticker"`
+}
+
+// Job shows a queued job, from its queueing to its result
+type Job struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	// Trigger is scheduled or manual
+	Trigger string   `json:"trigger"`
+	Tickers []string `json:"tickers,omitempty"`
+	// Status is queued, running, succeeded or failed
+	Status     string       `json:"status"`
+	Progress   *JobProgress `json:"progress,omitempty"`
+	Result     any          `json:"result,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	QueuedAt   string       `json:"queued_at"`
+	StartedAt  string       `json:"started_at,omitempty"`
+	FinishedAt string       `json:"finished_at,omitempty"`
+}
+
+// JobProgress shows how far a job got through its current stage
+type JobProgress struct {
+	Stage string `json:"stage"`
+	Done  int    `json:"done"`
+	Total int    `json:"total"`
+}
+
+// Brokerage shows a canonical brokerage, along with the aliases its ratings are given under
+type Brokerage struct {
+	ID      uint     `json:"id"`
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+}
+
+// BrokerageMergeRequest gives the brokerage to merge into another
+type BrokerageMergeRequest struct {
+	SourceID uint `json:"source_id"`
+	TargetID uint `json:"target_id"`
+}
+
+// BrokerageMergeResult shows the brokerage merged into, along with the ratings re-keyed to it
+type BrokerageMergeResult struct {
+	ID            uint     `json:"id"`
+	Name          string   `json:"name"`
+	Revisions     int64    `json:"revisions"`
+	Tickers       []string `json:"tickers"`
+	AnalysisJobID string   `json:"analysis_job_id,omitempty"`
+}
@@ -0,0 +1,19 @@
+package presenter
+
+// IngestResponse shows the outcome of each row of a pushed batch
+type IngestResponse struct {
+	Accepted int `json:"accepted"`
+	Rejected int `json:"rejected"`
+	// RefreshJobID is the job refreshing the info of the tickers that got new ratings, if any did
+	RefreshJobID string         `json:"refresh_job_id,omitempty"`
+	Rows         []IngestResult `json:"rows"`
+}
+
+// IngestResult shows whether a pushed row was accepted
+type IngestResult struct {
+	Row       int    `json:"row"`
+	Ticker    string `json:"ticker"`
+	Accepted  bool   `json:"accepted"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
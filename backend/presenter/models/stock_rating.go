@@ -2,21 +2,33 @@ package presenter
 
 // StockBase shows the basic information regarding a stock
 type StockBase struct {
-	Ticker         string  `json:"ticker"`
-	CompanyName    string  `json:"company_name"`
-	LastPrice      float64 `json:"last_price"`
-	Recommendation string  `json:"recommendation"`
+	Ticker      string  `json:"ticker"`
+	CompanyName string  `json:"company_name"`
+	LastPrice   float64 `json:"last_price"`
+	Currency    string  `json:"currency"`
+	// ReportingLastPrice is LastPrice in ReportingCurrency, missing when there is no FX rate for Currency
+	ReportingCurrency  string   `json:"reporting_currency"`
+	ReportingLastPrice *float64 `json:"reporting_last_price,omitempty"`
+	Recommendation     string   `json:"recommendation"`
 }
 
 // StockRating represents the information related to a stock rating
 type StockRating struct {
 	TargetFrom float64 `json:"target_from"`
 	TargetTo   float64 `json:"target_to"`
-	Action     string  `json:"action"`
-	Brokerage  string  `json:"brokerage"`
-	RatingFrom string  `json:"rating_from"`
-	RatingTo   string  `json:"rating_to"`
-	Time       string  `json:"time"`
+	Currency   string  `json:"currency"`
+	// ReportingTargetFrom and ReportingTargetTo are the targets in the reporting currency, missing when there is no
+	// FX rate for Currency
+	ReportingTargetFrom *float64 `json:"reporting_target_from,omitempty"`
+	ReportingTargetTo   *float64 `json:"reporting_target_to,omitempty"`
+	Action              string   `json:"action"`
+	// ActionType is the type of Action, and ActionDirection tells whether it is bullish (up), bearish (down) or neither
+	ActionType      string `json:"action_type"`
+	ActionDirection string `json:"action_direction"`
+	Brokerage       string `json:"brokerage"`
+	RatingFrom      string `json:"rating_from"`
+	RatingTo        string `json:"rating_to"`
+	Time            string `json:"time"`
 }
 
 // StockDetail represents the whole information of a stock and its details
@@ -25,5 +37,39 @@ type StockDetail struct {
 	StockRatings []StockRating `json:"stock_ratings"`
 }
 
+// StockRatingHistory lists every revision of the ratings given to a stock, ordered by brokerage and time
+type StockRatingHistory struct {
+	Ticker    string        `json:"ticker"`
+	Revisions []StockRating `json:"revisions"`
+}
+
+// StockPrices is the price history of a stock over a range, in points summarizing each interval of the range
+type StockPrices struct {
+	Ticker   string       `json:"ticker"`
+	Currency string       `json:"currency"`
+	Range    string       `json:"range"`
+	Interval string       `json:"interval"`
+	Points   []PricePoint `json:"points"`
+}
+
+// PricePoint summarizes the prices fetched within an interval, starting at Time
+type PricePoint struct {
+	Time    string  `json:"time"`
+	Open    float64 `json:"open"`
+	High    float64 `json:"high"`
+	Low     float64 `json:"low"`
+	Close   float64 `json:"close"`
+	Samples int     `json:"samples"`
+}
+
 // StockList gives a base list of all stocks
 type StockList []StockBase
+
+// UpstreamStatus shows the availability of an upstream API, as seen by its circuit breaker
+type UpstreamStatus struct {
+	Name                string `json:"name"`
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	OpenedAt            string `json:"opened_at,omitempty"`
+	LastError           string `json:"last_error,omitempty"`
+}
@@ -0,0 +1,94 @@
+package presenter
+
+import (
+	"github.com/c4ts0up/my-stocks/backend/models"
+	presenter "github.com/c4ts0up/my-stocks/backend/presenter/models"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// priceRanges maps the ranges of the price history to how far back they go from now. "all" goes back to the start
+var priceRanges = map[string]func(now time.Time) time.Time{
+	"1d":  func(now time.Time) time.Time { return now.AddDate(0, 0, -1) },
+	"5d":  func(now time.Time) time.Time { return now.AddDate(0, 0, -5) },
+	"1m":  func(now time.Time) time.Time { return now.AddDate(0, -1, 0) },
+	"3m":  func(now time.Time) time.Time { return now.AddDate(0, -3, 0) },
+	"6m":  func(now time.Time) time.Time { return now.AddDate(0, -6, 0) },
+	"1y":  func(now time.Time) time.Time { return now.AddDate(-1, 0, 0) },
+	"all": func(now time.Time) time.Time { return time.Time{} },
+}
+
+// priceIntervals maps the intervals of the price history to the start of the interval a time falls in, in UTC.
+// "raw" gives every snapshot on its own
+var priceIntervals = map[string]func(t time.Time) time.Time{
+	"raw": func(t time.Time) time.Time { return t.UTC() },
+	"1h":  func(t time.Time) time.Time { return t.UTC().Truncate(time.Hour) },
+	"1d":  startOfDay,
+	"1w": func(t time.Time) time.Time {
+		// weeks start on Monday
+		day := startOfDay(t)
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	},
+}
+
+// startOfDay gets the midnight, in UTC, of the day a time falls in
+func startOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// GetStockPrices handles GET /stocks/:ticker/prices?range=1m&interval=1d. Each point summarizes the prices fetched
+// within an interval of the range
+func GetStockPrices(c *gin.Context) {
+	ticker, ok := tickerParam(c)
+	if !ok {
+		return
+	}
+
+	priceRange := c.DefaultQuery("range", "1m")
+	rangeStart, ok := priceRanges[priceRange]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "range must be one of 1d, 5d, 1m, 3m, 6m, 1y or all"})
+		return
+	}
+	interval := c.DefaultQuery("interval", "1d")
+	bucketStart, ok := priceIntervals[interval]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "interval must be one of raw, 1h, 1d or 1w"})
+		return
+	}
+
+	var stock models.Stock
+	if result := models.DB.Where("ticker = ?", ticker).First(&stock); result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "stock not found"})
+		return
+	}
+
+	snapshots, err := models.GetPriceSnapshots(c.Request.Context(), models.DB, stock.Ticker, rangeStart(time.Now()))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch prices"})
+		return
+	}
+
+	buckets := models.BucketPriceSnapshots(snapshots, bucketStart)
+	prices := presenter.StockPrices{
+		Ticker:   stock.Ticker,
+		Currency: stock.Currency,
+		Range:    priceRange,
+		Interval: interval,
+		Points:   make([]presenter.PricePoint, len(buckets)),
+	}
+	for i, b := range buckets {
+		prices.Points[i] = presenter.PricePoint{
+			Time:    b.Start.Format(time.RFC3339Nano),
+			Open:    b.Open,
+			High:    b.High,
+			Low:     b.Low,
+			Close:   b.Close,
+			Samples: b.Samples,
+		}
+	}
+
+	c.JSON(http.StatusOK, prices)
+}
@@ -1,15 +1,18 @@
 package presenter
 
 import (
+	"errors"
 	"fmt"
 	"github.com/c4ts0up/my-stocks/backend/models"
 	presenter "github.com/c4ts0up/my-stocks/backend/presenter/models"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 	"net/http"
+	"slices"
 	"time"
 )
 
-// GetStocks handles GET /stocks
+// GetStocks handles GET /stocks. Responds 503 when there are no stocks yet because an upstream is unavailable
 func GetStocks(c *gin.Context) {
 	var stocks []models.Stock
 
@@ -18,14 +21,15 @@ func GetStocks(c *gin.Context) {
 		return
 	}
 
+	if len(stocks) == 0 && Upstreams.Degraded() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "downstream service unavailable"})
+		return
+	}
+
+	rates := loadFxRates(c)
 	stockBases := make([]presenter.StockBase, len(stocks))
 	for i, s := range stocks {
-		stockBases[i] = presenter.StockBase{
-			Ticker:         s.Ticker,
-			CompanyName:    s.Company,
-			LastPrice:      s.LastPrice,
-			Recommendation: s.Recommendation,
-		}
+		stockBases[i] = toStockBase(s, rates)
 	}
 
 	c.JSON(http.StatusOK, stockBases)
@@ -33,7 +37,10 @@ func GetStocks(c *gin.Context) {
 
 // GetStockDetail handles GET /stocks/:ticker
 func GetStockDetail(c *gin.Context) {
-	ticker := c.Param("ticker")
+	ticker, ok := tickerParam(c)
+	if !ok {
+		return
+	}
 
 	var stock models.Stock
 	if result := models.DB.Where("ticker = ?", ticker).First(&stock); result.Error != nil {
@@ -41,35 +48,175 @@ func GetStockDetail(c *gin.Context) {
 		return
 	}
 
+	query, ok := filterByAction(c, models.DB.Where("ticker = ?", ticker))
+	if !ok {
+		return
+	}
+
 	var stockRatings []models.StockRating
-	models.DB.Where("ticker = ?", ticker).Find(&stockRatings)
+	query.Find(&stockRatings)
 
+	rates := loadFxRates(c)
 	ratings := make([]presenter.StockRating, len(stockRatings))
 	for i, r := range stockRatings {
-		ratings[i] = presenter.StockRating{
-			TargetFrom: r.TargetFrom,
-			TargetTo:   r.TargetTo,
-			Action:     r.Action,
-			Brokerage:  r.Brokerage,
-			RatingFrom: r.RatingFrom,
-			RatingTo:   r.RatingTo,
-			Time:       r.Time.Format(time.RFC3339Nano),
-		}
+		ratings[i] = toStockRating(r, rates)
 	}
 
 	response := presenter.StockDetail{
-		StockBase: presenter.StockBase{
-			Ticker:         stock.Ticker,
-			CompanyName:    stock.Company,
-			LastPrice:      stock.LastPrice,
-			Recommendation: stock.Recommendation,
-		},
+		StockBase:    toStockBase(stock, rates),
 		StockRatings: ratings,
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
+// GetStockRatingHistory handles GET /stocks/:ticker/ratings/history
+func GetStockRatingHistory(c *gin.Context) {
+	ticker, ok := tickerParam(c)
+	if !ok {
+		return
+	}
+
+	var stock models.Stock
+	if result := models.DB.Where("ticker = ?", ticker).First(&stock); result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "stock not found"})
+		return
+	}
+
+	query, ok := filterByAction(c, models.DB.Where("ticker = ?", ticker))
+	if !ok {
+		return
+	}
+	// the brokerage is given by any of its aliases, while its revisions are saved under its canonical name
+	if brokerage := c.Query("brokerage"); brokerage != "" {
+		name, err := models.CanonicalBrokerageName(models.DB.WithContext(c.Request.Context()), brokerage)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch rating history"})
+			return
+		}
+		query = query.Where("brokerage = ?", name)
+	}
+
+	var revisions []models.StockRatingRevision
+	if result := query.Order("brokerage, time, id").Find(&revisions); result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch rating history"})
+		return
+	}
+
+	history := presenter.StockRatingHistory{
+		Ticker:    stock.Ticker,
+		Revisions: make([]presenter.StockRating, len(revisions)),
+	}
+	rates := loadFxRates(c)
+	for i, r := range revisions {
+		history.Revisions[i] = toStockRating(r.Rating(), rates)
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+// DeleteStock handles DELETE /admin/stocks/:ticker. Removes the stock along with its ratings, rating history and
+// price history
+func DeleteStock(c *gin.Context) {
+	ticker, ok := tickerParam(c)
+	if !ok {
+		return
+	}
+
+	err := models.DeleteStock(c.Request.Context(), models.DB, ticker)
+	if errors.Is(err, models.ErrStockNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "stock not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete the stock"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// tickerParam gets the ticker of the path in its canonical form, so "brk-b" finds BRK.B. Responds 400 and tells so
+// when it isn't a valid ticker
+func tickerParam(c *gin.Context) (string, bool) {
+	ticker, err := models.NormalizeTicker(c.Param("ticker"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ticker"})
+		return "", false
+	}
+	return ticker, true
+}
+
+// filterByAction filters the ratings by the action_type and direction query parameters. Responds 400 and tells so
+// when either is invalid
+func filterByAction(c *gin.Context, query *gorm.DB) (*gorm.DB, bool) {
+	var types []models.RatingActionType
+	if actionType := c.Query("action_type"); actionType != "" {
+		if !slices.Contains(models.RatingActionTypes, models.RatingActionType(actionType)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid action_type"})
+			return nil, false
+		}
+		types = append(types, models.RatingActionType(actionType))
+	}
+
+	if direction := c.Query("direction"); direction != "" {
+		if direction != models.DirectionUp && direction != models.DirectionDown && direction != models.DirectionNeutral {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid direction"})
+			return nil, false
+		}
+		// narrows the requested type, if any, down to the types of the direction
+		candidates := types
+		if len(candidates) == 0 {
+			candidates = models.RatingActionTypes
+		}
+		types = []models.RatingActionType{}
+		for _, t := range candidates {
+			if t.Direction() == direction {
+				types = append(types, t)
+			}
+		}
+		if len(types) == 0 {
+			return query.Where("1 = 0"), true
+		}
+	}
+
+	if len(types) > 0 {
+		query = query.Where("action_type IN ?", types)
+	}
+	return query, true
+}
+
+// toStockBase converts a Stock model to its presentation, along with its price in the reporting currency
+func toStockBase(s models.Stock, rates models.FxRates) presenter.StockBase {
+	return presenter.StockBase{
+		Ticker:             s.Ticker,
+		CompanyName:        s.Company,
+		LastPrice:          s.LastPrice,
+		Currency:           s.Currency,
+		ReportingCurrency:  ReportingCurrency,
+		ReportingLastPrice: reportingAmount(rates, s.LastPrice, s.Currency),
+		Recommendation:     s.Recommendation,
+	}
+}
+
+// toStockRating converts a StockRating model to its presentation, along with its targets in the reporting currency
+func toStockRating(r models.StockRating, rates models.FxRates) presenter.StockRating {
+	return presenter.StockRating{
+		TargetFrom:          r.TargetFrom,
+		TargetTo:            r.TargetTo,
+		Currency:            r.Currency,
+		ReportingTargetFrom: reportingAmount(rates, r.TargetFrom, r.Currency),
+		ReportingTargetTo:   reportingAmount(rates, r.TargetTo, r.Currency),
+		Action:              r.Action,
+		ActionType:          string(r.ActionType),
+		ActionDirection:     r.ActionType.Direction(),
+		Brokerage:           r.Brokerage,
+		RatingFrom:          r.RatingFrom,
+		RatingTo:            r.RatingTo,
+		Time:                r.Time.Format(time.RFC3339Nano),
+	}
+}
+
 func formatFloat(value float64) string {
 	return fmt.Sprintf("%.2f", value)
 }
@@ -0,0 +1,36 @@
+package presenter
+
+import (
+	"github.com/c4ts0up/my-stocks/backend/fetcher"
+	presenter "github.com/c4ts0up/my-stocks/backend/presenter/models"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// Upstreams holds the circuit breakers of the upstream APIs the stocks are fetched from
+var Upstreams fetcher.CircuitBreakers
+
+// GetUpstreams handles GET /health/upstreams. Responds 503 when some upstream is unavailable
+func GetUpstreams(c *gin.Context) {
+	statuses := Upstreams.Statuses()
+
+	response := make([]presenter.UpstreamStatus, len(statuses))
+	for i, s := range statuses {
+		response[i] = presenter.UpstreamStatus{
+			Name:                s.Name,
+			State:               s.State.String(),
+			ConsecutiveFailures: s.ConsecutiveFailures,
+			LastError:           s.LastError,
+		}
+		if !s.OpenedAt.IsZero() {
+			response[i].OpenedAt = s.OpenedAt.Format(time.RFC3339)
+		}
+	}
+
+	status := http.StatusOK
+	if Upstreams.Degraded() {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, response)
+}